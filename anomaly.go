@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+// Severities of detected anomalies.
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Anomaly describes a service cost of an account that deviates from its history.
+type Anomaly struct {
+	Category  string
+	AccountID string
+	Period    string
+	Service   string
+	Value     float64
+	Expected  float64
+	StdDev    float64
+	Months    int
+	Severity  string
+	// New is set for services that were not billed in any of the stored months.
+	New bool
+}
+
+// ZScore returns the deviation from the expected value in standard deviations.
+func (a Anomaly) ZScore() float64 {
+	if a.StdDev == 0 {
+		return 0
+	}
+	return (a.Value - a.Expected) / a.StdDev
+}
+
+func (a Anomaly) String() string {
+	if a.New {
+		return fmt.Sprintf("ANOMALY [%s] %s (group %s, %s): new service %s with %.2f, not billed in the previous %d stored months", a.Severity, a.AccountID, a.Category, a.Period, a.Service, a.Value, a.Months)
+	}
+	return fmt.Sprintf("ANOMALY [%s] %s (group %s, %s): service %s with %.2f, expected %.2f +/- %.2f from %d stored months (%+.1f sigma)", a.Severity, a.AccountID, a.Category, a.Period, a.Service, a.Value, a.Expected, a.StdDev, a.Months, a.ZScore())
+}
+
+// AnomalyDetector compares the service costs of an account with the service costs of the
+// previous months stored in the history database.
+type AnomalyDetector struct {
+	history      *HistoryStore
+	source       string
+	costType     string
+	months       int
+	sigma        float64
+	minChange    float64
+	newThreshold float64
+}
+
+// NewAnomalyDetector returns a new anomaly detector using the last months of history. Services
+// deviating more than sigma standard deviations and at least minChange from their mean are
+// flagged, as well as services not billed before with a cost of at least newThreshold.
+func NewAnomalyDetector(history *HistoryStore, source string, costType string, months int, sigma float64, minChange float64, newThreshold float64) (*AnomalyDetector, error) {
+	if months < 1 {
+		return nil, fmt.Errorf("anomaly detection needs at least one month of history, got %d", months)
+	}
+	if sigma <= 0 {
+		return nil, fmt.Errorf("anomaly detection needs a positive sigma, got %f", sigma)
+	}
+	detector := new(AnomalyDetector)
+	detector.history = history
+	detector.source = source
+	detector.costType = costType
+	detector.months = months
+	detector.sigma = sigma
+	detector.minChange = minChange
+	detector.newThreshold = newThreshold
+	return detector, nil
+}
+
+// Detect returns the anomalies of the service costs of an account for a month, sorted by
+// severity and deviation. Services of the stored months missing in the month are compared
+// with a cost of 0. Services need at least two stored months for the deviation check.
+func (d *AnomalyDetector) Detect(category string, accountID string, period DateRange, services map[string]float64) ([]Anomaly, error) {
+	records, err := d.history.PreviousMonths(d.source, d.costType, accountID, period.Start, d.months)
+	if err != nil {
+		return nil, err
+	}
+	anomalies := []Anomaly{}
+	if len(records) == 0 {
+		return anomalies, nil
+	}
+	// services billed in the stored months but not in the current month dropped to 0
+	compared := make(map[string]float64)
+	for _, record := range records {
+		for service := range record.Services {
+			compared[service] = 0
+		}
+	}
+	for service, value := range services {
+		compared[service] = value
+	}
+	for service, value := range compared {
+		values := []float64{}
+		billed := false
+		for _, record := range records {
+			// stored months without the service did not bill it
+			previous, ok := record.Services[service]
+			billed = billed || (ok && previous != 0)
+			values = append(values, previous)
+		}
+		anomaly := Anomaly{
+			Category:  category,
+			AccountID: accountID,
+			Period:    period.String(),
+			Service:   service,
+			Value:     value,
+			Months:    len(records),
+		}
+		if !billed {
+			if value == 0 || value < d.newThreshold {
+				continue
+			}
+			anomaly.New = true
+			anomaly.Severity = SeverityWarning
+			if value >= 10*d.newThreshold {
+				anomaly.Severity = SeverityCritical
+			}
+			anomalies = append(anomalies, anomaly)
+			continue
+		}
+		if len(values) < 2 {
+			continue
+		}
+		anomaly.Expected = mean(values)
+		anomaly.StdDev = stdDev(values)
+		if anomaly.StdDev == 0 || math.Abs(value-anomaly.Expected) < d.minChange {
+			continue
+		}
+		zScore := math.Abs(anomaly.ZScore())
+		if zScore <= d.sigma {
+			continue
+		}
+		anomaly.Severity = SeverityWarning
+		if zScore > 2*d.sigma {
+			anomaly.Severity = SeverityCritical
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+	sort.Slice(anomalies, func(i, j int) bool {
+		if anomalies[i].Severity != anomalies[j].Severity {
+			return anomalies[i].Severity == SeverityCritical
+		}
+		deviationI := math.Abs(anomalies[i].Value - anomalies[i].Expected)
+		deviationJ := math.Abs(anomalies[j].Value - anomalies[j].Expected)
+		if deviationI != deviationJ {
+			return deviationI > deviationJ
+		}
+		return anomalies[i].Service < anomalies[j].Service
+	})
+	return anomalies, nil
+}
+
+// detectAnomalies writes the anomalies of the service costs of an account to the report file.
+// Periods that are not whole months are not compared with the stored months.
+func detectAnomalies(detector *AnomalyDetector, reportfile *os.File, category string, accountID string, period DateRange, services map[string]float64) {
+	if detector == nil {
+		return
+	}
+	if !period.IsMonth() {
+		log.Printf("[detectanomalies] skipping anomaly detection for account %s, %s is not a whole month", accountID, period)
+		return
+	}
+	anomalies, err := detector.Detect(category, accountID, period, services)
+	if err != nil {
+		log.Printf("[detectanomalies] error detecting anomalies for account %s (%s): %v", accountID, period, err)
+		writeReport(reportfile, fmt.Sprintf("%s (%s): error detecting anomalies: %v", accountID, period, err))
+		return
+	}
+	for _, anomaly := range anomalies {
+		log.Printf("[detectanomalies] %s", anomaly)
+		writeReport(reportfile, anomaly.String())
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDetectAnomalies(t *testing.T) {
+	history, cleanup := openTestHistory(t)
+	defer cleanup()
+	rows := []ReportRow{}
+	for idx, month := range []string{"2026-06", "2026-07", "2026-08"} {
+		row := NewReportRow("a", month, "111111111111")
+		row.Services = map[string]float64{
+			"Amazon Elastic Compute Cloud - Compute": 1000 + float64(idx)*10,
+			"Amazon Simple Storage Service":          200 + float64(idx)*2,
+			"AWS Key Management Service":             1 + float64(idx),
+			"Amazon Relational Database Service":     500 + float64(idx)*50,
+			"AWS Config":                             2 + float64(idx),
+		}
+		rows = append(rows, *row)
+	}
+	err := history.PutRun(HistoryRun{ID: "run", Time: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), CostType: "UnblendedCost"}, SourceAWS, rows)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	detector, err := NewAnomalyDetector(history, SourceAWS, "UnblendedCost", 3, 3, 10, 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	september := mustParseDateRange(t, "2026-09", "2026-09")
+	anomalies, err := detector.Detect("a", "111111111111", september, map[string]float64{
+		// within the usual range
+		"Amazon Elastic Compute Cloud - Compute": 1015,
+		// far off, but below the minimum change
+		"AWS Key Management Service": 9,
+		// deviating more than 6 sigma
+		"Amazon Simple Storage Service": 300,
+		// new services below and above the threshold
+		"Amazon Route 53":  5,
+		"Amazon SageMaker": 250,
+		// Amazon Relational Database Service and AWS Config dropped to 0, only the former
+		// changed more than the minimum change
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(anomalies) != 3 {
+		t.Fatalf("expected 3 anomalies, got %+v", anomalies)
+	}
+	if anomalies[0].Service != "Amazon Relational Database Service" || anomalies[0].Severity != SeverityCritical || anomalies[0].Value != 0 || anomalies[0].Expected != 550 {
+		t.Errorf("unexpected dropped service anomaly: %+v", anomalies[0])
+	}
+	if anomalies[1].Service != "Amazon Simple Storage Service" || anomalies[1].Severity != SeverityCritical || anomalies[1].Expected != 202 || anomalies[1].Months != 3 {
+		t.Errorf("unexpected deviation anomaly: %+v", anomalies[1])
+	}
+	if anomalies[2].Service != "Amazon SageMaker" || !anomalies[2].New || anomalies[2].Severity != SeverityWarning {
+		t.Errorf("unexpected new service anomaly: %+v", anomalies[2])
+	}
+	// accounts without history have no anomalies
+	anomalies, err = detector.Detect("a", "222222222222", september, map[string]float64{"Amazon SageMaker": 250})
+	if err != nil || len(anomalies) != 0 {
+		t.Errorf("expected no anomalies without history, got %+v (%v)", anomalies, err)
+	}
+}
@@ -4,71 +4,149 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/costexplorer/costexploreriface"
 	"github.com/aws/aws-sdk-go/service/organizations"
-	"github.com/jinzhu/now"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
 )
 
 const AWSTagCostpullerCategory = "costpuller_category"
+const AWSTagCostpullerPO = "costpuller_po"
+const AWSTagCostpullerClusterID = "costpuller_clusterid"
+const AWSTagCostpullerClusterType = "costpuller_clustertype"
+const AWSTagCostpullerUsageType = "costpuller_usagetype"
+const AWSTagCostpullerProduct = "costpuller_product"
+const AWSTagCostpullerNumberUsers = "costpuller_numberusers"
 
 const AWSMetadataDescription = "description"
 const AWSMetadataStatus = "status"
 
 // AWSPuller implements the AWS query client
 type AWSPuller struct {
-	session *session.Session
+	costExplorer costexploreriface.CostExplorerAPI
+	organizations organizationsiface.OrganizationsAPI
+	costExplorerLimiter *RateLimiter
+	organizationsLimiter *RateLimiter
+	retrier *Retrier
+	converter *CurrencyConverter
 	debug bool
+	mapping *ServiceMapping
+	prefetchRange DateRange
+	prefetchCostType string
+	prefetched map[string][]AWSPeriodResult
 }
 
-// NewAWSPuller returns a new AWS client.
-func NewAWSPuller(debug bool) *AWSPuller {
-	awsp := new(AWSPuller)
-	awsp.session = session.Must(session.NewSessionWithOptions(session.Options{
+// NewAWSPuller returns a new AWS client. Pulled amounts are converted with the converter.
+// Failed requests are only retried by the retrier, the retries of the SDK are disabled.
+func NewAWSPuller(debug bool, mapping *ServiceMapping, retrier *Retrier, converter *CurrencyConverter) *AWSPuller {
+	awsSession := session.Must(session.NewSessionWithOptions(session.Options{
+    Config: *aws.NewConfig().WithMaxRetries(0),
     SharedConfigState: session.SharedConfigEnable,
 	}))
+	return NewAWSPullerWithClients(debug, mapping, retrier, converter, costexplorer.New(awsSession), organizations.New(awsSession))
+}
+
+// NewAWSPullerWithClients returns a new AWS client using the given service clients.
+func NewAWSPullerWithClients(debug bool, mapping *ServiceMapping, retrier *Retrier, converter *CurrencyConverter, costExplorer costexploreriface.CostExplorerAPI, organizations organizationsiface.OrganizationsAPI) *AWSPuller {
+	awsp := new(AWSPuller)
+	awsp.costExplorer = costExplorer
+	awsp.organizations = organizations
+	awsp.costExplorerLimiter = NewRateLimiter(CostExplorerRequestsPerSecond, CostExplorerBurst)
+	awsp.organizationsLimiter = NewRateLimiter(OrganizationsRequestsPerSecond, OrganizationsBurst)
 	awsp.debug = debug
+	awsp.mapping = mapping
+	awsp.retrier = retrier
+	awsp.converter = converter
 	return awsp
 }
 
-// PullData retrieves a raw data set.
-func (a *AWSPuller) PullData(accountID string, month string, costType string) (map[string]float64, error) {
-	// check month format
-	focusMonth, err := time.Parse("2006-01", month)
-	if err != nil {
-		log.Printf("[pullawsdata] month format error: %v\n", err)
-		return nil, err
+// Record types that are separated from the service costs into their own columns.
+const (
+	RecordTypeCredit = "Credit"
+	RecordTypeRefund = "Refund"
+	RecordTypeTax    = "Tax"
+)
+
+var separatedRecordTypes = []string{RecordTypeCredit, RecordTypeRefund, RecordTypeTax}
+
+func isSeparatedRecordType(recordType string) bool {
+	for _, separated := range separatedRecordTypes {
+		if separated == recordType {
+			return true
+		}
+	}
+	return false
+}
+
+// AWSPeriodResult holds the service and record type breakdown for one period of a pull in
+// the reporting currency. The services do not include the cost of the separated record types (credits, refunds and tax).
+type AWSPeriodResult struct {
+	Period      DateRange
+	Services    map[string]float64
+	RecordTypes map[string]float64
+	Conversion  Conversion
+}
+
+// Costs returns the service costs together with the costs of the separated record types,
+// adding up to the net cost of the period.
+func (r AWSPeriodResult) Costs() map[string]float64 {
+	costs := make(map[string]float64)
+	for service, value := range r.Services {
+		costs[service] += value
+	}
+	for _, recordType := range separatedRecordTypes {
+		if value, ok := r.RecordTypes[recordType]; ok {
+			costs[recordType] += value
+		}
+	}
+	return costs
+}
+
+// PullData retrieves a raw data set, one result per month in the date range.
+func (a *AWSPuller) PullData(accountID string, dateRange DateRange, costType string) ([]AWSPeriodResult, error) {
+	if IsUsageMetric(costType) {
+		return nil, fmt.Errorf("cost type %s is a usage metric and can not be pulled as cost", costType)
 	}
-	beginningOfMonth := now.With(focusMonth).BeginningOfMonth()
-	endOfMonth := now.With(focusMonth).EndOfMonth().Add(time.Hour * 24)
-	dayStart := beginningOfMonth.Format("2006-01-02")
-	dayEnd := endOfMonth.Format("2006-01-02")
-	log.Printf("[pullawsdata] using date range %s to %s", dayStart, dayEnd)
+	if results, ok := a.prefetched[accountID]; ok && a.prefetchRange == dateRange && a.prefetchCostType == costType {
+		log.Printf("[pullawsdata] using prefetched data for account %s", accountID)
+		return results, nil
+	}
+	return a.pullData(accountID, dateRange, costType, costexplorer.GranularityMonthly)
+}
+
+// pullData retrieves a raw data set, one result per period of the granularity in the date range.
+func (a *AWSPuller) pullData(accountID string, dateRange DateRange, costType string, granularity string) ([]AWSPeriodResult, error) {
+	dayStart := dateRange.StartDay()
+	dayEnd := dateRange.EndDay()
+	log.Printf("[pullawsdata] using date range %s to %s with granularity %s", dayStart, dayEnd, granularity)
 	// retrieve AWS cost
-	svc := costexplorer.New(a.session)
-	granularity := "MONTHLY"
 	metricsBlendedCost := costType
 	log.Printf("[pullawsdata] using cost type %s", metricsBlendedCost)
 	dimensionLinkedAccountKey := "LINKED_ACCOUNT"
 	dimensionLinkedAccountValue := accountID
 	groupByDimension := "DIMENSION"
 	groupByService := "SERVICE"
-	costAndUsageService, err := svc.GetCostAndUsage(&costexplorer.GetCostAndUsageInput{
+	groupByRecordType := "RECORD_TYPE"
+	accountFilter := &costexplorer.Expression{
+		Dimensions: &costexplorer.DimensionValues{
+			Key: &dimensionLinkedAccountKey,
+			Values: []*string{&dimensionLinkedAccountValue},
+		},
+	}
+	costAndUsageService, err := a.getCostAndUsageAllPages(&costexplorer.GetCostAndUsageInput{
 		TimePeriod: &costexplorer.DateInterval{
 			Start: &dayStart,
 			End: &dayEnd,
 		},
 		Granularity: &granularity,
 		Metrics: []*string{&metricsBlendedCost},
-		Filter: &costexplorer.Expression{
-			Dimensions: &costexplorer.DimensionValues{
-				Key: &dimensionLinkedAccountKey,
-				Values: []*string{&dimensionLinkedAccountValue},
-			},
-		},
+		Filter: withoutSeparatedRecordTypes(accountFilter),
 		GroupBy: []*costexplorer.GroupDefinition{
 			&costexplorer.GroupDefinition{
 				Type: &groupByDimension,
@@ -80,141 +158,443 @@ func (a *AWSPuller) PullData(accountID string, month string, costType string) (m
 		log.Printf("[pullawsdata] error retrieving aws service cost report: %v\n", err)
 		return nil, err
 	}
-	if a.debug {
-		log.Println("[pullawsdata] received service breakdown report:")
-		log.Println(*costAndUsageService)
-	}
-	costAndUsageTotal, err := svc.GetCostAndUsage(&costexplorer.GetCostAndUsageInput{
+	costAndUsageRecordType, err := a.getCostAndUsageAllPages(&costexplorer.GetCostAndUsageInput{
 		TimePeriod: &costexplorer.DateInterval{
 			Start: &dayStart,
 			End: &dayEnd,
 		},
 		Granularity: &granularity,
 		Metrics: []*string{&metricsBlendedCost},
-		Filter: &costexplorer.Expression{
-			Dimensions: &costexplorer.DimensionValues{
+		Filter: accountFilter,
+		GroupBy: []*costexplorer.GroupDefinition{
+			&costexplorer.GroupDefinition{
+				Type: &groupByDimension,
+				Key: &groupByRecordType,
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("[pullawsdata] error retrieving aws record type cost report: %v\n", err)
+		return nil, err
+	}
+	if len(costAndUsageService.ResultsByTime) != len(costAndUsageRecordType.ResultsByTime) {
+		log.Printf("[pullawsdata] error: account %s has %d service results by time but %d record type results by time", accountID, len(costAndUsageService.ResultsByTime), len(costAndUsageRecordType.ResultsByTime))
+		return nil, fmt.Errorf("[pullawsdata] error: account %s has %d service results by time but %d record type results by time", accountID, len(costAndUsageService.ResultsByTime), len(costAndUsageRecordType.ResultsByTime))
+	}
+	results := []AWSPeriodResult{}
+	for idx, serviceResultByTime := range costAndUsageService.ResultsByTime {
+		result, err := a.decodePeriod(accountID, costType, serviceResultByTime, costAndUsageRecordType.ResultsByTime[idx])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// PrefetchData retrieves the service breakdown for all given accounts using batched
+// queries grouped by linked account and service. Subsequent calls to PullData for these
+// accounts with the same date range and cost type are served from the prefetched data.
+func (a *AWSPuller) PrefetchData(accountIDs []string, dateRange DateRange, costType string) error {
+	dayStart := dateRange.StartDay()
+	dayEnd := dateRange.EndDay()
+	log.Printf("[prefetchawsdata] using date range %s to %s for %d accounts", dayStart, dayEnd, len(accountIDs))
+	granularity := "MONTHLY"
+	dimensionLinkedAccountKey := "LINKED_ACCOUNT"
+	groupByDimension := "DIMENSION"
+	groupByService := "SERVICE"
+	groupByRecordType := "RECORD_TYPE"
+	accountValues := []*string{}
+	for idx := range accountIDs {
+		accountValues = append(accountValues, &accountIDs[idx])
+	}
+	filter := &costexplorer.Expression{
+		Dimensions: &costexplorer.DimensionValues{
+			Key: &dimensionLinkedAccountKey,
+			Values: accountValues,
+		},
+	}
+	costAndUsageService, err := a.getCostAndUsageAllPages(&costexplorer.GetCostAndUsageInput{
+		TimePeriod: &costexplorer.DateInterval{
+			Start: &dayStart,
+			End: &dayEnd,
+		},
+		Granularity: &granularity,
+		Metrics: []*string{&costType},
+		Filter: withoutSeparatedRecordTypes(filter),
+		GroupBy: []*costexplorer.GroupDefinition{
+			&costexplorer.GroupDefinition{
+				Type: &groupByDimension,
 				Key: &dimensionLinkedAccountKey,
-				Values: []*string{&dimensionLinkedAccountValue},
+			},
+			&costexplorer.GroupDefinition{
+				Type: &groupByDimension,
+				Key: &groupByService,
 			},
 		},
 	})
 	if err != nil {
-		log.Printf("[pullawsdata] error retrieving aws total cost report: %v\n", err)
+		log.Printf("[prefetchawsdata] error retrieving aws service cost report: %v\n", err)
+		return err
+	}
+	costAndUsageRecordType, err := a.getCostAndUsageAllPages(&costexplorer.GetCostAndUsageInput{
+		TimePeriod: &costexplorer.DateInterval{
+			Start: &dayStart,
+			End: &dayEnd,
+		},
+		Granularity: &granularity,
+		Metrics: []*string{&costType},
+		Filter: filter,
+		GroupBy: []*costexplorer.GroupDefinition{
+			&costexplorer.GroupDefinition{
+				Type: &groupByDimension,
+				Key: &dimensionLinkedAccountKey,
+			},
+			&costexplorer.GroupDefinition{
+				Type: &groupByDimension,
+				Key: &groupByRecordType,
+			},
+		},
+	})
+	if err != nil {
+		log.Printf("[prefetchawsdata] error retrieving aws record type cost report: %v\n", err)
+		return err
+	}
+	if len(costAndUsageService.ResultsByTime) != len(costAndUsageRecordType.ResultsByTime) {
+		log.Printf("[prefetchawsdata] error: %d service results by time but %d record type results by time", len(costAndUsageService.ResultsByTime), len(costAndUsageRecordType.ResultsByTime))
+		return fmt.Errorf("[prefetchawsdata] error: %d service results by time but %d record type results by time", len(costAndUsageService.ResultsByTime), len(costAndUsageRecordType.ResultsByTime))
+	}
+	// fan out the batched results into per account results
+	prefetched := make(map[string][]AWSPeriodResult)
+	for idx, serviceResultByTime := range costAndUsageService.ResultsByTime {
+		recordTypeResultByTime := costAndUsageRecordType.ResultsByTime[idx]
+		for _, accountID := range accountIDs {
+			accountServiceResult := splitResultByAccount(accountID, serviceResultByTime)
+			accountRecordTypeResult := splitResultByAccount(accountID, recordTypeResultByTime)
+			result, err := a.decodePeriod(accountID, costType, accountServiceResult, accountRecordTypeResult)
+			if err != nil {
+				return err
+			}
+			prefetched[accountID] = append(prefetched[accountID], *result)
+		}
+	}
+	a.prefetchRange = dateRange
+	a.prefetchCostType = costType
+	a.prefetched = prefetched
+	log.Printf("[prefetchawsdata] done prefetching data for %d accounts", len(prefetched))
+	return nil
+}
+
+// withoutSeparatedRecordTypes returns the filter restricted to record types that are not
+// separated from the service costs.
+func withoutSeparatedRecordTypes(filter *costexplorer.Expression) *costexplorer.Expression {
+	dimensionRecordTypeKey := "RECORD_TYPE"
+	recordTypeValues := []*string{}
+	for idx := range separatedRecordTypes {
+		recordTypeValues = append(recordTypeValues, &separatedRecordTypes[idx])
+	}
+	return &costexplorer.Expression{
+		And: []*costexplorer.Expression{
+			filter,
+			&costexplorer.Expression{
+				Not: &costexplorer.Expression{
+					Dimensions: &costexplorer.DimensionValues{
+						Key: &dimensionRecordTypeKey,
+						Values: recordTypeValues,
+					},
+				},
+			},
+		},
+	}
+}
+
+// splitResultByAccount extracts the groups of one linked account from a batched result. The
+// account key is removed from the group keys.
+func splitResultByAccount(accountID string, resultByTime *costexplorer.ResultByTime) *costexplorer.ResultByTime {
+	split := &costexplorer.ResultByTime{
+		TimePeriod: resultByTime.TimePeriod,
+		Groups:     []*costexplorer.Group{},
+	}
+	// accounts without cost in a period do not show up in the groups
+	for _, group := range resultByTime.Groups {
+		if len(group.Keys) == 0 || *group.Keys[0] != accountID {
+			continue
+		}
+		split.Groups = append(split.Groups, &costexplorer.Group{
+			Keys:    group.Keys[1:],
+			Metrics: group.Metrics,
+		})
+	}
+	return split
+}
+
+// getCostAndUsageAllPages runs a cost and usage query following all result pages. The groups
+// of all pages are merged into the results by time of the first page.
+func (a *AWSPuller) getCostAndUsageAllPages(input *costexplorer.GetCostAndUsageInput) (*costexplorer.GetCostAndUsageOutput, error) {
+	var output *costexplorer.GetCostAndUsageOutput
+	err := a.callCostExplorer("GetCostAndUsage", func() (err error) {
+		output, err = a.costExplorer.GetCostAndUsage(input)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
+	pages := 1
+	for output.NextPageToken != nil && *output.NextPageToken != "" {
+		input.NextPageToken = output.NextPageToken
+		var page *costexplorer.GetCostAndUsageOutput
+		err := a.callCostExplorer("GetCostAndUsage", func() (err error) {
+			page, err = a.costExplorer.GetCostAndUsage(input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		pages++
+		for _, pageResultByTime := range page.ResultsByTime {
+			merged := false
+			for _, resultByTime := range output.ResultsByTime {
+				if *resultByTime.TimePeriod.Start == *pageResultByTime.TimePeriod.Start {
+					resultByTime.Groups = append(resultByTime.Groups, pageResultByTime.Groups...)
+					merged = true
+					break
+				}
+			}
+			if !merged {
+				output.ResultsByTime = append(output.ResultsByTime, pageResultByTime)
+			}
+		}
+		output.NextPageToken = page.NextPageToken
+	}
+	input.NextPageToken = nil
 	if a.debug {
-		log.Println("[pullawsdata] received total report:")
-		log.Println(*costAndUsageTotal)
+		log.Printf("[getcostandusageallpages] received report with %d result pages:", pages)
+		log.Println(*output)
+	} else if pages > 1 {
+		log.Printf("[getcostandusageallpages] merged %d result pages", pages)
+	}
+	return output, nil
+}
+
+// AWSGroupValue holds the value of one group of a grouped pull.
+type AWSGroupValue struct {
+	Keys   []string
+	Amount float64
+	Unit   string
+}
+
+// AWSGroupedResult holds the groups of one period of a grouped pull.
+type AWSGroupedResult struct {
+	Period DateRange
+	Groups []AWSGroupValue
+}
+
+// dimensionGroups returns group definitions for the given dimension keys.
+func dimensionGroups(keys ...string) []*costexplorer.GroupDefinition {
+	groupByDimension := "DIMENSION"
+	groups := []*costexplorer.GroupDefinition{}
+	for idx := range keys {
+		groups = append(groups, &costexplorer.GroupDefinition{
+			Type: &groupByDimension,
+			Key: &keys[idx],
+		})
+	}
+	return groups
+}
+
+// PullGrouped retrieves the values of a metric for an account with monthly granularity, grouped
+// by up to two group definitions. An additional filter is combined with the account filter if
+// given. The values keep their units, no consistency checks are done.
+func (a *AWSPuller) PullGrouped(accountID string, dateRange DateRange, metric string, groupBy []*costexplorer.GroupDefinition, filter *costexplorer.Expression) ([]AWSGroupedResult, error) {
+	dayStart := dateRange.StartDay()
+	dayEnd := dateRange.EndDay()
+	granularity := costexplorer.GranularityMonthly
+	dimensionLinkedAccountKey := "LINKED_ACCOUNT"
+	queryFilter := &costexplorer.Expression{
+		Dimensions: &costexplorer.DimensionValues{
+			Key: &dimensionLinkedAccountKey,
+			Values: []*string{&accountID},
+		},
+	}
+	if filter != nil {
+		queryFilter = &costexplorer.Expression{
+			And: []*costexplorer.Expression{queryFilter, filter},
+		}
+	}
+	log.Printf("[pullawsgrouped] using date range %s to %s with metric %s for account %s", dayStart, dayEnd, metric, accountID)
+	costAndUsage, err := a.getCostAndUsageAllPages(&costexplorer.GetCostAndUsageInput{
+		TimePeriod: &costexplorer.DateInterval{
+			Start: &dayStart,
+			End: &dayEnd,
+		},
+		Granularity: &granularity,
+		Metrics: []*string{&metric},
+		Filter: queryFilter,
+		GroupBy: groupBy,
+	})
+	if err != nil {
+		log.Printf("[pullawsgrouped] error retrieving aws grouped report: %v\n", err)
+		return nil, err
+	}
+	results := []AWSGroupedResult{}
+	for _, resultByTime := range costAndUsage.ResultsByTime {
+		period, err := decodeTimePeriod(resultByTime.TimePeriod)
+		if err != nil {
+			log.Printf("[pullawsgrouped] error decoding time period: %v", err)
+			return nil, err
+		}
+		result := AWSGroupedResult{Period: period, Groups: []AWSGroupValue{}}
+		for _, group := range resultByTime.Groups {
+			value, ok := group.Metrics[metric]
+			if !ok || value.Amount == nil {
+				log.Printf("[pullawsgrouped] error: account %s group %v has no value for %s", accountID, aws.StringValueSlice(group.Keys), metric)
+				return nil, fmt.Errorf("[pullawsgrouped] error: account %s group %v has no value for %s", accountID, aws.StringValueSlice(group.Keys), metric)
+			}
+			amount, err := strconv.ParseFloat(*value.Amount, 64)
+			if err != nil {
+				log.Printf("[pullawsgrouped] error converting aws value: %v", err)
+				return nil, err
+			}
+			result.Groups = append(result.Groups, AWSGroupValue{
+				Keys:   aws.StringValueSlice(group.Keys),
+				Amount: amount,
+				Unit:   aws.StringValue(value.Unit),
+			})
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// groupConversion returns the conversion of the amount of a group of a grouped pull into the
+// reporting currency.
+func (a *AWSPuller) groupConversion(period DateRange, group AWSGroupValue) (Conversion, error) {
+	return a.converter.Conversion(group.Unit, period.Start.Format(monthFormat))
+}
+
+// callCostExplorer runs a rate limited Cost Explorer request, retrying on retryable errors.
+func (a *AWSPuller) callCostExplorer(operation string, fn func() error) error {
+	return a.retrier.Do(operation, func() error {
+		a.costExplorerLimiter.Wait()
+		return fn()
+	})
+}
+
+// callOrganizations runs a rate limited Organizations request, retrying on retryable errors.
+func (a *AWSPuller) callOrganizations(operation string, fn func() error) error {
+	return a.retrier.Do(operation, func() error {
+		a.organizationsLimiter.Wait()
+		return fn()
+	})
+}
+
+// decodePeriod decodes the service and record type breakdown of a period and checks the
+// services against the record types not separated from the service costs.
+func (a *AWSPuller) decodePeriod(accountID string, costType string, serviceResultByTime *costexplorer.ResultByTime, recordTypeResultByTime *costexplorer.ResultByTime) (*AWSPeriodResult, error) {
+	period, err := decodeTimePeriod(serviceResultByTime.TimePeriod)
+	if err != nil {
+		log.Printf("[pullawsdata] error decoding time period: %v", err)
+		return nil, err
 	}
-	// decode total value
-	totalAWSStr := *(*(*costAndUsageTotal.ResultsByTime[0]).Total[metricsBlendedCost]).Amount
-	totalAWS, err := strconv.ParseFloat(totalAWSStr, 64)
+	if *serviceResultByTime.TimePeriod.Start != *recordTypeResultByTime.TimePeriod.Start {
+		log.Printf("[pullawsdata] error: account %s service period %s does not match record type period %s", accountID, *serviceResultByTime.TimePeriod.Start, *recordTypeResultByTime.TimePeriod.Start)
+		return nil, fmt.Errorf("[pullawsdata] error: account %s service period %s does not match record type period %s", accountID, *serviceResultByTime.TimePeriod.Start, *recordTypeResultByTime.TimePeriod.Start)
+	}
+	// decode record type data, the total is the sum of all record types
+	recordTypeResults, unit, err := decodeGroups(accountID, costType, recordTypeResultByTime.Groups, "")
 	if err != nil {
-		log.Printf("[pullawsdata] error converting aws total value: %v", err)
 		return nil, err
 	}
-	unitAWS := *(*(*costAndUsageTotal.ResultsByTime[0]).Total[metricsBlendedCost]).Unit
-	if unitAWS != "USD" {
-		log.Printf("[pullawsdata] pulled unit is not USD: %s", unitAWS)
-		return nil, fmt.Errorf("pulled unit is not USD: %s", unitAWS)
+	var totalAWS float64 = 0
+	for recordType, value := range recordTypeResults {
+		if !isSeparatedRecordType(recordType) {
+			totalAWS += value
+		}
 	}
 	// decode service data
+	serviceResults, unit, err := decodeGroups(accountID, costType, serviceResultByTime.Groups, unit)
+	if err != nil {
+		return nil, err
+	}
 	var totalService float64 = 0
-	serviceResults := make(map[string]float64)
-	resultsByTime := costAndUsageService.ResultsByTime
-	if len(resultsByTime) != 1 {
-		log.Printf("[pullawsdata] warning account %s does not have exactly one service results by time (has %d)", accountID, len(resultsByTime))
-		return serviceResults, nil
-	}
-	serviceGroups := resultsByTime[0].Groups
-	for _, group := range(serviceGroups) {
+	for _, value := range serviceResults {
+		totalService += value
+	}
+	if math.Round(totalService*100)/100 != math.Round(totalAWS*100)/100  {
+		log.Printf("[pullawsdata] error: account %s service total %f does not match aws total %f for %s", accountID, totalService, totalAWS, period)
+		return nil, fmt.Errorf("[pullawsdata] error: account %s service total %f does not match aws total %f for %s", accountID, totalService, totalAWS, period)
+	}
+	// periods without cost have no unit, their amounts are in the reporting currency
+	conversion, err := a.converter.Conversion(unit, period.Start.Format(monthFormat))
+	if err != nil {
+		log.Printf("[pullawsdata] error converting account %s data for %s: %v", accountID, period, err)
+		return nil, err
+	}
+	for _, results := range []map[string]float64{serviceResults, recordTypeResults} {
+		for key, value := range results {
+			results[key] = value * conversion.Rate
+		}
+	}
+	return &AWSPeriodResult{
+		Period:      period,
+		Services:    serviceResults,
+		RecordTypes: recordTypeResults,
+		Conversion:  conversion,
+	}, nil
+}
+
+// decodeGroups decodes the values of groups with a single key. All values need to have the
+// same unit, given as expected unit if known. Returns the unit of the values.
+func decodeGroups(accountID string, costType string, groups []*costexplorer.Group, expectedUnit string) (map[string]float64, string, error) {
+	results := make(map[string]float64)
+	for _, group := range groups {
 		if len(group.Keys) != 1 {
-			log.Printf("[pullawsdata] warning account %s service group does not have exactly one key", accountID)
-			return serviceResults, fmt.Errorf("[pullawsdata] warning account %s service group does not have exactly one key", accountID)
+			log.Printf("[pullawsdata] warning account %s group does not have exactly one key", accountID)
+			return nil, "", fmt.Errorf("[pullawsdata] warning account %s group does not have exactly one key", accountID)
 		}
 		key := group.Keys[0]
 		valueStr := group.Metrics[costType].Amount
 		unit := group.Metrics[costType].Unit
-		if *unit != unitAWS {
-			log.Printf("[pullawsdata] error: inconsistent units (%s vs %s) for account %s", unitAWS, *unit, accountID)
-			return nil, fmt.Errorf("[pullawsdata] error: inconsistent units (%s vs %s) for account %s", unitAWS, *unit, accountID)
+		if expectedUnit != "" && *unit != expectedUnit {
+			log.Printf("[pullawsdata] error: inconsistent units (%s vs %s) for account %s", expectedUnit, *unit, accountID)
+			return nil, "", fmt.Errorf("[pullawsdata] error: inconsistent units (%s vs %s) for account %s", expectedUnit, *unit, accountID)
 		}
+		expectedUnit = *unit
 		value, err := strconv.ParseFloat(*valueStr, 64)
 		if err != nil {
-			log.Printf("[pullawsdata] error converting aws service value: %v", err)
-			return nil, err
+			log.Printf("[pullawsdata] error converting aws value: %v", err)
+			return nil, "", err
 		}
-		serviceResults[*key] = value
-		totalService += value
+		results[*key] += value
 	}
-	if math.Round(totalService*100)/100 != math.Round(totalAWS*100)/100  {
-		log.Printf("[pullawsdata] error: account %s service total %f does not match aws total %f", accountID, totalService, totalAWS)
-		return nil, fmt.Errorf("[pullawsdata] error: account %s service total %f does not match aws total %f", accountID, totalService, totalAWS)
+	return results, expectedUnit, nil
+}
+
+// decodeTimePeriod converts a Cost Explorer interval into a DateRange.
+func decodeTimePeriod(interval *costexplorer.DateInterval) (DateRange, error) {
+	start, err := time.Parse(dayFormat, *interval.Start)
+	if err != nil {
+		return DateRange{}, err
 	}
-	return serviceResults, nil
+	end, err := time.Parse(dayFormat, *interval.End)
+	if err != nil {
+		return DateRange{}, err
+	}
+	return DateRange{Start: start, End: end}, nil
 }
 
-// NormalizeResponse normalizes a Response object data into report categories.
-func (a *AWSPuller) NormalizeResponse(group string, daterange string, accountID string, serviceResults map[string]float64) ([]string, error) {
-	// format is: 
-	// group, date, clusterId, accountId, PO, clusterType, usageType, product, infra, numberUsers, dataTransfer, machines, storage, keyMgmnt, registrar, dns, other, tax, refund
-
-	// remove: 2 4 5 6 7 9 
-	output := make([]string, 13)
-	for idx := range(output) {
-		output[idx] = "PENDING"
-	}
-	// set group
-	output[0] = group
-	// infra is always AWS
-	output[3] = "AWS"
-	// set date - we use the first service entry
-	output[1] = daterange
-	// set clusterID
-	output[2] = accountID
-	// init cost values
-	output[4] = "0"
-	output[5] = "0"
-	output[6] = "0"
-	output[7] = "0"
-	output[8] = "0"
-	output[9] = "0"
-	output[10] = "0"
-	output[11] = "0"
-	output[12] = "0"	
+// NormalizeResponse normalizes a Response object data into report categories, filling the
+// credit, refund and tax columns from the record types. Also returns
+// the services that have no column mapping and were added to the other column.
+func (a *AWSPuller) NormalizeResponse(group string, daterange string, accountID string, serviceResults map[string]float64, recordTypeResults map[string]float64) (*ReportRow, map[string]float64, error) {
+	output := NewReportRow(group, daterange, accountID)
+	output.Services = serviceResults
 	// nomalize cost values
-	var ec2Val float64 = 0
-	var kmVal float64 = 0
-	var otherVal float64 = 0
-	for key, value := range(serviceResults) {
-		switch key {
-		case "AWS Data Transfer":
-			output[4] = fmt.Sprintf("%f", value)
-		case "Amazon Elastic Compute Cloud - Compute":
-			ec2Val += value
-		case "EC2 - Other":
-			ec2Val += value
-		case "Amazon Simple Storage Service":
-			output[6] = fmt.Sprintf("%f", value)
-		case "AWS Key Management Service":
-			kmVal += value
-		case "AWS Secrets Manager":
-			kmVal += value
-		case "Amazon Route 53":
-			output[9] = fmt.Sprintf("%f", value)
-		case "Tax":
-			output[11] = fmt.Sprintf("%f", value)
-		default:
-			otherVal += value
-		}
-	}
-	// EC2
-	output[5] = fmt.Sprintf("%f", ec2Val)
-	// key management
-	output[7] = fmt.Sprintf("%f", kmVal)
-	// store other total
-	output[10] = fmt.Sprintf("%f", otherVal)
-	return output, nil
+	columns, unmapped := a.mapping.Normalize(SourceAWS, serviceResults)
+	output.AddColumns(columns)
+	// credits, refunds and tax are not part of the service costs
+	output.AddRecordTypes(recordTypeResults)
+	return output, unmapped, nil
 }
 
 // CheckResponseConsistency checks the response consistency with various checks. Returns the calculated total.
@@ -242,7 +622,8 @@ func (a *AWSPuller) CheckResponseConsistency(account AccountEntry, results map[s
 }
 
 // GetAWSAccountMetadata returns a map with accountIDs as keys and metadata key-value pairs map as value.
-func (a *AWSPuller) GetAWSAccountMetadata() (map[string]map[string]string, error) {
+// Tags are pulled using up to concurrency parallel workers.
+func (a *AWSPuller) GetAWSAccountMetadata(concurrency int) (map[string]map[string]string, error) {
 	// get account list and basic metadata
 	accounts, err := a.getAllAWSAccountData()
 	if err != nil {
@@ -250,16 +631,22 @@ func (a *AWSPuller) GetAWSAccountMetadata() (map[string]map[string]string, error
 	}
 	// augment tags
 	log.Println("[GetAWSAccountMetadata] starting tags pull for accounts")
-	idx := 0
-	for accountID, _ := range accounts { 
-		idx++
-		log.Printf("[GetAWSAccountMetadata] pulling tags for account %s (%d of %d)", accountID, idx, len(accounts))
-
-		tags, err := a.getTagsForAWSAccount(accountID)
-		if err != nil {
-			return nil, err
+	accountIDs := make([]string, 0, len(accounts))
+	for accountID := range accounts {
+		accountIDs = append(accountIDs, accountID)
+	}
+	sort.Strings(accountIDs)
+	accountTags := make([]map[string]string, len(accountIDs))
+	accountErrs := make([]error, len(accountIDs))
+	runParallel(len(accountIDs), concurrency, func(idx int) {
+		log.Printf("[GetAWSAccountMetadata] pulling tags for account %s (%d of %d)", accountIDs[idx], idx+1, len(accountIDs))
+		accountTags[idx], accountErrs[idx] = a.getTagsForAWSAccount(accountIDs[idx])
+	})
+	for idx, accountID := range accountIDs {
+		if accountErrs[idx] != nil {
+			return nil, accountErrs[idx]
 		}
-		for tagKey, tagValue := range tags {
+		for tagKey, tagValue := range accountTags[idx] {
 			accounts[accountID][tagKey] = tagValue
 		}
 	}
@@ -268,10 +655,13 @@ func (a *AWSPuller) GetAWSAccountMetadata() (map[string]map[string]string, error
 
 func (a *AWSPuller) getTagsForAWSAccount(accountID string) (map[string]string, error) {
 	result := map[string]string{}
-	svo := organizations.New(a.session)
-	output, err := svo.ListTagsForResource(&organizations.ListTagsForResourceInput{
-		NextToken:  nil,
-		ResourceId: &accountID,
+	var output *organizations.ListTagsForResourceOutput
+	err := a.callOrganizations("ListTagsForResource " + accountID, func() (err error) {
+		output, err = a.organizations.ListTagsForResource(&organizations.ListTagsForResourceInput{
+			NextToken:  nil,
+			ResourceId: &accountID,
+		})
+		return err
 	})
 	if err != nil {
 		log.Printf("[pullawsdata] error getting account tags: %v", err)
@@ -281,9 +671,13 @@ func (a *AWSPuller) getTagsForAWSAccount(accountID string) (map[string]string, e
 		result[*e.Key] = *e.Value
 	}
 	for output.NextToken != nil && *output.NextToken != "" {
-		output, err = svo.ListTagsForResource(&organizations.ListTagsForResourceInput{
-			ResourceId: &accountID,
-			NextToken:  output.NextToken,
+		nextToken := output.NextToken
+		err = a.callOrganizations("ListTagsForResource " + accountID, func() (err error) {
+			output, err = a.organizations.ListTagsForResource(&organizations.ListTagsForResourceInput{
+				ResourceId: &accountID,
+				NextToken:  nextToken,
+			})
+			return err
 		})
 		if err != nil {
 			log.Printf("[pullawsdata] error getting account tags: %v", err)
@@ -296,11 +690,15 @@ func (a *AWSPuller) getTagsForAWSAccount(accountID string) (map[string]string, e
 	return result, nil
 }
 
-func (a *AWSPuller) pullAccountData(svo *organizations.Organizations, result *map[string]map[string]string, nextToken *string) (*string, error) {
+func (a *AWSPuller) pullAccountData(result *map[string]map[string]string, nextToken *string) (*string, error) {
 	limit := int64(10)
-	output, err := svo.ListAccounts(&organizations.ListAccountsInput{
-		MaxResults: &limit,
-		NextToken:  nextToken,
+	var output *organizations.ListAccountsOutput
+	err := a.callOrganizations("ListAccounts", func() (err error) {
+		output, err = a.organizations.ListAccounts(&organizations.ListAccountsInput{
+			MaxResults: &limit,
+			NextToken:  nextToken,
+		})
+		return err
 	})
 	if err != nil {
 		log.Printf("[pullawsdata] error getting account list: %v", err)
@@ -317,15 +715,14 @@ func (a *AWSPuller) pullAccountData(svo *organizations.Organizations, result *ma
 
 func (a *AWSPuller) getAllAWSAccountData() (map[string]map[string]string, error) {
 	result := map[string]map[string]string{}
-	svo := organizations.New(a.session)
 	log.Println("[pullawsdata] pulling all accounts metadata")
-	nextToken, err := a.pullAccountData(svo, &result, nil)
+	nextToken, err := a.pullAccountData(&result, nil)
 	if err != nil {
 		return nil, err
 	}
 	for nextToken != nil && *nextToken != "" {
 		log.Printf("[pullawsdata] pulling more accounts metadata, pulled %d accounts", len(result))
-		nextToken, err = a.pullAccountData(svo, &result, nextToken)
+		nextToken, err = a.pullAccountData(&result, nextToken)
 		if err != nil {
 			log.Printf("[pullawsdata] error getting account list: %v", err)
 			return nil, err
@@ -336,21 +733,19 @@ func (a *AWSPuller) getAllAWSAccountData() (map[string]map[string]string, error)
 }
 
 func (a *AWSPuller) WriteAWSTags(accounts map[string][]AccountEntry) (error) {
-	svo := organizations.New(a.session)
-	catgoryTag := AWSTagCostpullerCategory
 	for category, accountEntries := range accounts {
 		for _, accountEntry := range accountEntries {
-			fmt.Printf("setting tag %s == %s for account %s...", catgoryTag, category, accountEntry.AccountID)
+			tags := accountTags(category, accountEntry)
+			fmt.Printf("setting %d tags (%s == %s) for account %s...", len(tags), AWSTagCostpullerCategory, category, accountEntry.AccountID)
 			if !a.debug {
-				_, err := svo.TagResource(&organizations.TagResourceInput{
-					ResourceId: &accountEntry.AccountID,
-					Tags:       []*organizations.Tag{
-						&organizations.Tag{
-							Key: &catgoryTag, 
-							Value: &category,	
-						},
-					},
-				})	
+				accountID := accountEntry.AccountID
+				err := a.callOrganizations("TagResource " + accountID, func() error {
+					_, err := a.organizations.TagResource(&organizations.TagResourceInput{
+						ResourceId: &accountID,
+						Tags:       tags,
+					})
+					return err
+				})
 				if err != nil {
 					return err
 				}
@@ -362,3 +757,27 @@ func (a *AWSPuller) WriteAWSTags(accounts map[string][]AccountEntry) (error) {
 	}
 	return nil
 }
+
+// accountTags returns the category tag and the tags for all metadata set on the account entry.
+func accountTags(category string, accountEntry AccountEntry) []*organizations.Tag {
+	values := [][2]string{
+		{AWSTagCostpullerCategory, category},
+		{AWSTagCostpullerPO, accountEntry.PO},
+		{AWSTagCostpullerClusterID, accountEntry.ClusterID},
+		{AWSTagCostpullerClusterType, accountEntry.ClusterType},
+		{AWSTagCostpullerUsageType, accountEntry.UsageType},
+		{AWSTagCostpullerProduct, accountEntry.Product},
+		{AWSTagCostpullerNumberUsers, accountEntry.NumberUsers},
+	}
+	tags := []*organizations.Tag{}
+	for idx := range values {
+		if values[idx][1] == "" {
+			continue
+		}
+		tags = append(tags, &organizations.Tag{
+			Key:   &values[idx][0],
+			Value: &values[idx][1],
+		})
+	}
+	return tags
+}
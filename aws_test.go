@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/costexplorer/costexploreriface"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+)
+
+// fakeCostExplorer serves GetCostAndUsage responses from a fixture file. Responses are
+// keyed by the comma separated group by keys of a query ("" for ungrouped queries), the
+// page is selected by the NextPageToken of the query.
+type fakeCostExplorer struct {
+	costexploreriface.CostExplorerAPI
+	responses map[string][]json.RawMessage
+	calls     int
+}
+
+func (f *fakeCostExplorer) GetCostAndUsage(input *costexplorer.GetCostAndUsageInput) (*costexplorer.GetCostAndUsageOutput, error) {
+	f.calls++
+	keys := []string{}
+	for _, group := range input.GroupBy {
+		keys = append(keys, *group.Key)
+	}
+	pages, ok := f.responses[strings.Join(keys, ",")]
+	if !ok {
+		return nil, fmt.Errorf("no fixture for group by %v", keys)
+	}
+	page := 0
+	if input.NextPageToken != nil {
+		page, _ = strconv.Atoi(*input.NextPageToken)
+	}
+	output := new(costexplorer.GetCostAndUsageOutput)
+	err := json.Unmarshal(pages[page], output)
+	return output, err
+}
+
+// GetCostForecast serves the forecast responses keyed by "forecast".
+func (f *fakeCostExplorer) GetCostForecast(input *costexplorer.GetCostForecastInput) (*costexplorer.GetCostForecastOutput, error) {
+	f.calls++
+	pages, ok := f.responses["forecast"]
+	if !ok {
+		return nil, fmt.Errorf("no forecast fixture")
+	}
+	output := new(costexplorer.GetCostForecastOutput)
+	err := json.Unmarshal(pages[0], output)
+	return output, err
+}
+
+// fakeOrganizations serves account and tag pages from a fixture file and records tag writes.
+type fakeOrganizations struct {
+	organizationsiface.OrganizationsAPI
+	Accounts []*organizations.ListAccountsOutput
+	Tags     map[string][]*organizations.ListTagsForResourceOutput
+	tagged   []*organizations.TagResourceInput
+}
+
+func (f *fakeOrganizations) ListAccounts(input *organizations.ListAccountsInput) (*organizations.ListAccountsOutput, error) {
+	return f.Accounts[pageIndex(input.NextToken)], nil
+}
+
+func (f *fakeOrganizations) ListTagsForResource(input *organizations.ListTagsForResourceInput) (*organizations.ListTagsForResourceOutput, error) {
+	pages, ok := f.Tags[*input.ResourceId]
+	if !ok {
+		return nil, fmt.Errorf("no tags fixture for account %s", *input.ResourceId)
+	}
+	return pages[pageIndex(input.NextToken)], nil
+}
+
+func (f *fakeOrganizations) TagResource(input *organizations.TagResourceInput) (*organizations.TagResourceOutput, error) {
+	f.tagged = append(f.tagged, input)
+	return &organizations.TagResourceOutput{}, nil
+}
+
+func pageIndex(token *string) int {
+	if token == nil {
+		return 0
+	}
+	page, _ := strconv.Atoi(*token)
+	return page
+}
+
+func readFixture(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("error reading fixture %s: %v", name, err)
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		t.Fatalf("error parsing fixture %s: %v", name, err)
+	}
+}
+
+func newFixturePuller(t *testing.T, costExplorerFixture string) (*AWSPuller, *fakeCostExplorer, *fakeOrganizations) {
+	t.Helper()
+	ce := &fakeCostExplorer{}
+	if costExplorerFixture != "" {
+		readFixture(t, costExplorerFixture, &ce.responses)
+	}
+	org := &fakeOrganizations{}
+	readFixture(t, "organizations.json", org)
+	return NewAWSPullerWithClients(false, DefaultServiceMapping(), nil, nil, ce, org), ce, org
+}
+
+func mustParseDateRange(t *testing.T, from string, to string) DateRange {
+	t.Helper()
+	dateRange, err := ParseDateRange("", from, to)
+	if err != nil {
+		t.Fatalf("error parsing date range: %v", err)
+	}
+	return dateRange
+}
+
+func TestPullDataGroupsServices(t *testing.T) {
+	puller, ce, _ := newFixturePuller(t, "costexplorer_services.json")
+	results, err := puller.PullData("111111111111", mustParseDateRange(t, "2026-01", ""), "UnblendedCost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ce.calls != 3 {
+		t.Errorf("expected 3 calls for two service pages and the record types, got %d", ce.calls)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected one period, got %d", len(results))
+	}
+	if results[0].Period.String() != "2026-01" {
+		t.Errorf("expected period 2026-01, got %s", results[0].Period)
+	}
+	if len(results[0].Services) != 8 {
+		t.Errorf("expected services of both pages to be merged, got %v", results[0].Services)
+	}
+	if results[0].RecordTypes[RecordTypeCredit] != -20 || results[0].RecordTypes[RecordTypeTax] != 3 {
+		t.Errorf("expected credit and tax record types, got %v", results[0].RecordTypes)
+	}
+	if len(results[0].Costs()) != 10 {
+		t.Errorf("expected services and separated record types in costs, got %v", results[0].Costs())
+	}
+	normalized, unmapped, err := puller.NormalizeResponse("someGroup", results[0].Period.String(), "111111111111", results[0].Services, results[0].RecordTypes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(unmapped) != 1 || unmapped["Amazon SageMaker"] != 7 {
+		t.Errorf("expected SageMaker to be reported as unmapped, got %v", unmapped)
+	}
+	expected := ReportRow{
+		Group:        "someGroup",
+		Date:         "2026-01",
+		ClusterID:    PendingValue,
+		AccountID:    "111111111111",
+		PO:           PendingValue,
+		ClusterType:  PendingValue,
+		UsageType:    PendingValue,
+		Product:      PendingValue,
+		Infra:        "AWS",
+		NumberUsers:  PendingValue,
+		DataTransfer: 5,
+		Machines:     120,
+		Storage:      10,
+		KeyMgmnt:     3,
+		DNS:          0.5,
+		Other:        7,
+		Tax:          3,
+		Credit:       -20,
+		Conversion:   Conversion{Currency: "USD", OriginalCurrency: "USD", Rate: 1},
+		Services:     results[0].Services,
+	}
+	if !reflect.DeepEqual(*normalized, expected) {
+		t.Errorf("expected row %v, got %v", expected, *normalized)
+	}
+	if normalized.Gross() != 145.5 || normalized.Total() != 128.5 {
+		t.Errorf("expected gross 145.5 and net 128.5, got %f and %f", normalized.Gross(), normalized.Total())
+	}
+}
+
+func TestPullDataTotalMismatch(t *testing.T) {
+	puller, _, _ := newFixturePuller(t, "costexplorer_total_mismatch.json")
+	_, err := puller.PullData("111111111111", mustParseDateRange(t, "2026-01", ""), "UnblendedCost")
+	if err == nil || !strings.Contains(err.Error(), "does not match aws total") {
+		t.Fatalf("expected total mismatch error, got %v", err)
+	}
+}
+
+func TestPullDataNonUSD(t *testing.T) {
+	puller, _, _ := newFixturePuller(t, "costexplorer_non_usd.json")
+	puller.converter = NewCurrencyConverter(nil, "USD")
+	_, err := puller.PullData("111111111111", mustParseDateRange(t, "2026-01", ""), "UnblendedCost")
+	if err == nil || !strings.Contains(err.Error(), "without an exchange rate file") {
+		t.Fatalf("expected missing exchange rate error, got %v", err)
+	}
+	rates := &ExchangeRates{Base: "USD", Rates: map[string]map[string]float64{"2026-01": {"EUR": 0.8}}}
+	puller, _, _ = newFixturePuller(t, "costexplorer_non_usd.json")
+	puller.converter = NewCurrencyConverter(rates, "USD")
+	results, err := puller.PullData("111111111111", mustParseDateRange(t, "2026-01", ""), "UnblendedCost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if results[0].Services["Amazon Elastic Compute Cloud - Compute"] != 125 {
+		t.Errorf("expected 100 EUR to be converted to 125 USD, got %v", results[0].Services)
+	}
+	expected := Conversion{Currency: "USD", OriginalCurrency: "EUR", Rate: 1.25}
+	if results[0].Conversion != expected {
+		t.Errorf("expected conversion %v, got %v", expected, results[0].Conversion)
+	}
+}
+
+func TestPrefetchData(t *testing.T) {
+	puller, ce, _ := newFixturePuller(t, "costexplorer_batch.json")
+	dateRange := mustParseDateRange(t, "2026-01", "2026-02")
+	err := puller.PrefetchData([]string{"111111111111", "222222222222"}, dateRange, "UnblendedCost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ce.calls != 3 {
+		t.Errorf("expected 3 calls for two service pages and the record types, got %d", ce.calls)
+	}
+	results, err := puller.PullData("222222222222", dateRange, "UnblendedCost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ce.calls != 3 {
+		t.Errorf("expected prefetched data to be used, got %d calls", ce.calls)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected two periods, got %d", len(results))
+	}
+	if results[0].Services["Amazon Route 53"] != 1 || len(results[1].Services) != 0 {
+		t.Errorf("unexpected services for account: %v", results)
+	}
+}
+
+func TestGetAWSAccountMetadata(t *testing.T) {
+	puller, _, _ := newFixturePuller(t, "")
+	metadata, err := puller.GetAWSAccountMetadata(2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(metadata) != 2 {
+		t.Fatalf("expected accounts of both pages, got %v", metadata)
+	}
+	account := metadata["111111111111"]
+	if account[AWSTagCostpullerCategory] != "someGroup" || account["owner"] != "team" {
+		t.Errorf("expected tags of both pages, got %v", account)
+	}
+	if account[AWSMetadataDescription] != "cluster one" || metadata["222222222222"][AWSMetadataStatus] != "SUSPENDED" {
+		t.Errorf("unexpected account metadata: %v", metadata)
+	}
+}
+
+func TestWriteAWSTags(t *testing.T) {
+	puller, _, org := newFixturePuller(t, "")
+	accounts := map[string][]AccountEntry{
+		"someGroup": []AccountEntry{
+			AccountEntry{AccountID: "111111111111", PO: "4711", Product: "product"},
+			AccountEntry{AccountID: "222222222222"},
+		},
+	}
+	err := puller.WriteAWSTags(accounts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(org.tagged) != 2 {
+		t.Fatalf("expected two tag writes, got %d", len(org.tagged))
+	}
+	for _, input := range org.tagged {
+		if *input.Tags[0].Key != AWSTagCostpullerCategory || *input.Tags[0].Value != "someGroup" {
+			t.Errorf("unexpected category tag for account %s: %v", *input.ResourceId, input.Tags)
+		}
+	}
+	if len(org.tagged[0].Tags) != 3 || *org.tagged[0].Tags[1].Key != AWSTagCostpullerPO || *org.tagged[0].Tags[2].Value != "product" {
+		t.Errorf("expected metadata tags for account, got %v", org.tagged[0].Tags)
+	}
+	if len(org.tagged[1].Tags) != 1 {
+		t.Errorf("expected only category tag for account without metadata, got %v", org.tagged[1].Tags)
+	}
+	// debug mode does not write tags
+	org.tagged = nil
+	puller.debug = true
+	err = puller.WriteAWSTags(accounts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(org.tagged) != 0 {
+		t.Errorf("expected no tag writes in debug mode, got %d", len(org.tagged))
+	}
+}
+
+func TestNewAWSPullerDisablesSDKRetries(t *testing.T) {
+	puller := NewAWSPuller(false, DefaultServiceMapping(), nil, nil)
+	for name, api := range map[string]interface{ MaxRetries() int }{
+		"cost explorer": puller.costExplorer.(*costexplorer.CostExplorer),
+		"organizations": puller.organizations.(*organizations.Organizations),
+	} {
+		if retries := api.MaxRetries(); retries != 0 {
+			t.Errorf("expected %s sdk retries to be disabled, got %d", name, retries)
+		}
+	}
+}
+
+// readCSVOutput calls write with a temporary file and returns the csv records written to it.
+func readCSVOutput(t *testing.T, write func(*os.File) error) [][]string {
+	t.Helper()
+	outfile, err := ioutil.TempFile("", "costpuller")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(outfile.Name())
+	err = write(outfile)
+	outfile.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.Open(outfile.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer data.Close()
+	records, err := csv.NewReader(data).ReadAll()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return records
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Calibration methods for computing the expected value.
+const (
+	CalibrateMean   = "mean"
+	CalibrateMedian = "median"
+)
+
+// DefaultCalibrationMonths is the number of previous months the calibrate mode uses if no
+// number is given.
+const DefaultCalibrationMonths = 6
+
+// MinCalibratedDeviationPercent is the lowest allowed deviation of calibrated accounts. It
+// keeps accounts with identical stored totals from failing the deviation check on any change.
+const MinCalibratedDeviationPercent = 5
+
+// Calibration describes the expected total of an account computed from its history.
+type Calibration struct {
+	Expected float64
+	StdDev   float64
+	Months   int
+}
+
+// Calibrator computes expected account totals from the totals of previous months stored in
+// the history database.
+type Calibrator struct {
+	history  *HistoryStore
+	source   string
+	costType string
+	months   int
+	method   string
+	sigma    float64
+}
+
+// NewCalibrator returns a new calibrator using the last months of history. Allowed deviations
+// are sigma standard deviations around the expected value.
+func NewCalibrator(history *HistoryStore, source string, costType string, months int, method string, sigma float64) (*Calibrator, error) {
+	if method != CalibrateMean && method != CalibrateMedian {
+		return nil, fmt.Errorf("unknown calibration method %s, needs to be one of %s or %s", method, CalibrateMean, CalibrateMedian)
+	}
+	if months < 1 {
+		return nil, fmt.Errorf("calibration needs at least one month of history, got %d", months)
+	}
+	cal := new(Calibrator)
+	cal.history = history
+	cal.source = source
+	cal.costType = costType
+	cal.months = months
+	cal.method = method
+	cal.sigma = sigma
+	return cal, nil
+}
+
+// Calibrate computes the calibration for an account from the months before the given time.
+// Returns nil if no month is stored.
+func (c *Calibrator) Calibrate(accountID string, before time.Time) (*Calibration, error) {
+	records, err := c.history.PreviousMonths(c.source, c.costType, accountID, before, c.months)
+	if err != nil {
+		return nil, err
+	}
+	values := []float64{}
+	for _, record := range records {
+		values = append(values, record.Total)
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	calibration := &Calibration{
+		StdDev: stdDev(values),
+		Months: len(values),
+	}
+	if c.method == CalibrateMedian {
+		calibration.Expected = median(values)
+	} else {
+		calibration.Expected = mean(values)
+	}
+	return calibration, nil
+}
+
+// Apply returns a copy of the account with standard value and deviation set from the
+// calibration. If the history has no spread, the deviation of the account is kept. The
+// deviation is at least MinCalibratedDeviationPercent.
+func (c *Calibrator) Apply(account AccountEntry, calibration *Calibration) AccountEntry {
+	account.Standardvalue = math.Round(calibration.Expected*100) / 100
+	if calibration.Expected > 0 && calibration.StdDev > 0 {
+		account.Deviationpercent = int(math.Ceil(c.sigma * calibration.StdDev / calibration.Expected * 100))
+	}
+	if account.Deviationpercent < MinCalibratedDeviationPercent {
+		account.Deviationpercent = MinCalibratedDeviationPercent
+	}
+	return account
+}
+
+// calibrateAccount returns the account with calibrated standard values for a period. Accounts
+// without history are returned unchanged.
+func calibrateAccount(calibrator *Calibrator, account AccountEntry, period DateRange) AccountEntry {
+	if calibrator == nil {
+		return account
+	}
+	calibration, err := calibrator.Calibrate(account.AccountID, period.Start)
+	if err != nil {
+		log.Printf("[calibrateaccount] error calibrating account %s, using configured standard value: %v", account.AccountID, err)
+		return account
+	}
+	if calibration == nil {
+		log.Printf("[calibrateaccount] no history for account %s, using configured standard value", account.AccountID)
+		return account
+	}
+	calibrated := calibrator.Apply(account, calibration)
+	log.Printf("[calibrateaccount] calibrated account %s to standard value %.2f with deviation %d%% from %d months", account.AccountID, calibrated.Standardvalue, calibrated.Deviationpercent, calibration.Months)
+	return calibrated
+}
+
+// writeCalibratedAccounts rewrites the accounts file with calibrated standard values. Accounts
+// without history keep their values. The previous file is kept with a .bak suffix.
+func writeCalibratedAccounts(calibrator *Calibrator, accountsFile string, accounts map[string][]AccountEntry, before time.Time) error {
+	suggested := make(map[string][]AccountEntry)
+	for category, accountEntries := range accounts {
+		for _, account := range accountEntries {
+			calibration, err := calibrator.Calibrate(account.AccountID, before)
+			if err != nil {
+				return err
+			}
+			if calibration != nil {
+				calibrated := calibrator.Apply(account, calibration)
+				log.Printf("[writecalibratedaccounts] account %s: standard value %.2f -> %.2f, deviation %d%% -> %d%% (%d months)", account.AccountID, account.Standardvalue, calibrated.Standardvalue, account.Deviationpercent, calibrated.Deviationpercent, calibration.Months)
+				account = calibrated
+			} else {
+				log.Printf("[writecalibratedaccounts] account %s: no history, keeping values", account.AccountID)
+			}
+			// the category is given by the key
+			account.Category = ""
+			suggested[category] = append(suggested[category], account)
+		}
+	}
+	out, err := yaml.Marshal(suggested)
+	if err != nil {
+		return err
+	}
+	original, err := ioutil.ReadFile(accountsFile)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(accountsFile+".bak", original, 0644)
+	if err != nil {
+		return err
+	}
+	log.Printf("[writecalibratedaccounts] writing suggested values to %s, previous file kept as %s.bak", accountsFile, accountsFile)
+	return ioutil.WriteFile(accountsFile, out, 0644)
+}
+
+func mean(values []float64) float64 {
+	var sum float64 = 0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// stdDev returns the sample standard deviation, 0 for less than two values.
+func stdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	m := mean(values)
+	var sum float64 = 0
+	for _, value := range values {
+		sum += (value - m) * (value - m)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
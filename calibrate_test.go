@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestStatistics(t *testing.T) {
+	values := []float64{100, 140, 120, 110}
+	if got := mean(values); got != 117.5 {
+		t.Errorf("expected mean 117.5, got %f", got)
+	}
+	if got := median(values); got != 115 {
+		t.Errorf("expected median 115, got %f", got)
+	}
+	if got := median([]float64{3, 1, 2}); got != 2 {
+		t.Errorf("expected median 2, got %f", got)
+	}
+	if got := stdDev([]float64{100}); got != 0 {
+		t.Errorf("expected no deviation for a single value, got %f", got)
+	}
+	if got := stdDev([]float64{90, 110}); got < 14.142 || got > 14.143 {
+		t.Errorf("expected deviation 14.142, got %f", got)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	history, cleanup := openTestHistory(t)
+	defer cleanup()
+	rows := []ReportRow{
+		historyTestRow("a", "2026-01", "111111111111", 90),
+		historyTestRow("a", "2026-02", "111111111111", 110),
+		// outside of the calibration window
+		historyTestRow("a", "2025-10", "111111111111", 1000),
+		// the calibrated month itself is not used
+		historyTestRow("a", "2026-04", "111111111111", 1000),
+	}
+	err := history.PutRun(HistoryRun{ID: "run", Time: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), CostType: "UnblendedCost"}, SourceAWS, rows)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calibrator, err := NewCalibrator(history, SourceAWS, "UnblendedCost", 3, CalibrateMean, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	april := mustParseDateRange(t, "2026-04", "2026-04")
+	calibration, err := calibrator.Calibrate("111111111111", april.Start)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calibration == nil || calibration.Expected != 100 || calibration.Months != 2 {
+		t.Fatalf("unexpected calibration: %+v", calibration)
+	}
+	account := calibrateAccount(calibrator, AccountEntry{AccountID: "111111111111", Standardvalue: 500, Deviationpercent: 5}, april)
+	// two standard deviations of 14.14 around 100
+	if account.Standardvalue != 100 || account.Deviationpercent != 29 {
+		t.Errorf("unexpected calibrated account: %+v", account)
+	}
+	// identical stored totals have no spread, the deviation is raised to the minimum
+	applied := calibrator.Apply(AccountEntry{AccountID: "111111111111"}, &Calibration{Expected: 100, Months: 3})
+	if applied.Standardvalue != 100 || applied.Deviationpercent != MinCalibratedDeviationPercent {
+		t.Errorf("expected minimum deviation without spread, got %+v", applied)
+	}
+	applied = calibrator.Apply(AccountEntry{AccountID: "111111111111"}, &Calibration{Expected: 1000, StdDev: 1, Months: 3})
+	if applied.Deviationpercent != MinCalibratedDeviationPercent {
+		t.Errorf("expected minimum deviation for a small spread, got %+v", applied)
+	}
+	unknown := AccountEntry{AccountID: "222222222222", Standardvalue: 500, Deviationpercent: 5}
+	if got := calibrateAccount(calibrator, unknown, april); got != unknown {
+		t.Errorf("expected account without history to be unchanged, got %+v", got)
+	}
+	if _, err := NewCalibrator(history, SourceAWS, "UnblendedCost", 3, "mode", 2); err == nil {
+		t.Error("expected error for unknown method")
+	}
+}
+
+func TestWriteCalibratedAccounts(t *testing.T) {
+	history, cleanup := openTestHistory(t)
+	defer cleanup()
+	rows := []ReportRow{
+		historyTestRow("a", "2026-02", "111111111111", 80),
+		historyTestRow("a", "2026-03", "111111111111", 120),
+	}
+	err := history.PutRun(HistoryRun{ID: "run", Time: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), CostType: "UnblendedCost"}, SourceAWS, rows)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "costpuller")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	accountsFile := filepath.Join(dir, "accounts.yaml")
+	original := []byte("a:\n  - accountid: \"111111111111\"\n    standardvalue: 10\n    deviationpercent: 5\n    description: cluster\n  - accountid: \"222222222222\"\n    standardvalue: 20\n    deviationpercent: 5\n")
+	err = ioutil.WriteFile(accountsFile, original, 0644)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	accounts, err := getAccountSetsFromFile(accountsFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calibrator, err := NewCalibrator(history, SourceAWS, "UnblendedCost", 6, CalibrateMedian, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = writeCalibratedAccounts(calibrator, accountsFile, accounts, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	backup, err := ioutil.ReadFile(accountsFile + ".bak")
+	if err != nil || string(backup) != string(original) {
+		t.Errorf("expected backup of the original file, got %q (%v)", backup, err)
+	}
+	written, err := ioutil.ReadFile(accountsFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	calibrated := map[string][]AccountEntry{}
+	err = yaml.Unmarshal(written, calibrated)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []AccountEntry{
+		{AccountID: "111111111111", Standardvalue: 100, Deviationpercent: 29, Description: "cluster"},
+		{AccountID: "222222222222", Standardvalue: 20, Deviationpercent: 5},
+	}
+	if len(calibrated["a"]) != 2 || calibrated["a"][0] != expected[0] || calibrated["a"][1] != expected[1] {
+		t.Errorf("unexpected calibrated accounts: %+v", calibrated)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+// ColumnTotal is the name of the sum of all cost columns in change reports.
+const ColumnTotal = "total"
+
+// ChangeRow describes the change of a cost column between the previous and the current month,
+// either for an account or, with an empty account id, for a whole category.
+type ChangeRow struct {
+	Category  string
+	AccountID string
+	Column    string
+	Previous  float64
+	Current   float64
+}
+
+// Change returns the absolute change.
+func (c ChangeRow) Change() float64 {
+	return c.Current - c.Previous
+}
+
+// ChangePercent returns the change in percent of the previous value. Returns false if there
+// was no previous value.
+func (c ChangeRow) ChangePercent() (float64, bool) {
+	if c.Previous == 0 {
+		return 0, false
+	}
+	return c.Change() / math.Abs(c.Previous) * 100, true
+}
+
+// IsMover returns true if the change exceeds both the absolute and the percent threshold.
+// Values appearing without a previous value only need to exceed the absolute threshold.
+func (c ChangeRow) IsMover(thresholdAbs float64, thresholdPercent float64) bool {
+	if math.Abs(c.Change()) < thresholdAbs {
+		return false
+	}
+	percent, ok := c.ChangePercent()
+	return !ok || math.Abs(percent) >= thresholdPercent
+}
+
+// Column returns the value of a normalized cost column by name.
+func (r *ReportRow) Column(column string) float64 {
+	switch column {
+	case ColumnDataTransfer:
+		return r.DataTransfer
+	case ColumnMachines:
+		return r.Machines
+	case ColumnStorage:
+		return r.Storage
+	case ColumnKeyMgmnt:
+		return r.KeyMgmnt
+	case ColumnRegistrar:
+		return r.Registrar
+	case ColumnDNS:
+		return r.DNS
+	case ColumnOther:
+		return r.Other
+	case ColumnTax:
+		return r.Tax
+	case ColumnRefund:
+		return r.Refund
+	case ColumnCredit:
+		return r.Credit
+	case ColumnGross:
+		return r.Gross()
+	case ColumnTotal, ColumnNet:
+		return r.Total()
+	}
+	return 0
+}
+
+// computeChanges compares the rows of the previous and the current month per category and
+// account. Category rows come first, followed by the account rows of the category. Failed
+// rows are skipped.
+func computeChanges(previousMonth string, currentMonth string, rows []ReportRow) []ChangeRow {
+	columns := append(append([]string{}, mappingColumns...), ColumnTotal)
+	type changeKey struct {
+		category  string
+		accountID string
+		column    string
+	}
+	changes := map[changeKey]*ChangeRow{}
+	add := func(key changeKey, month string, value float64) {
+		change, ok := changes[key]
+		if !ok {
+			change = &ChangeRow{Category: key.category, AccountID: key.accountID, Column: key.column}
+			changes[key] = change
+		}
+		if month == previousMonth {
+			change.Previous += value
+		} else {
+			change.Current += value
+		}
+	}
+	for _, row := range rows {
+		if row.Failed != "" || (row.Date != previousMonth && row.Date != currentMonth) {
+			continue
+		}
+		for _, column := range columns {
+			value := row.Column(column)
+			add(changeKey{row.Group, "", column}, row.Date, value)
+			add(changeKey{row.Group, row.AccountID, column}, row.Date, value)
+		}
+	}
+	columnOrder := map[string]int{}
+	for idx, column := range columns {
+		columnOrder[column] = idx
+	}
+	result := make([]ChangeRow, 0, len(changes))
+	for _, change := range changes {
+		result = append(result, *change)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Category != result[j].Category {
+			return result[i].Category < result[j].Category
+		}
+		if result[i].AccountID != result[j].AccountID {
+			return result[i].AccountID < result[j].AccountID
+		}
+		return columnOrder[result[i].Column] < columnOrder[result[j].Column]
+	})
+	return result
+}
+
+// topMovers returns the changes exceeding the thresholds, sorted by absolute change.
+func topMovers(changes []ChangeRow, thresholdAbs float64, thresholdPercent float64) []ChangeRow {
+	movers := []ChangeRow{}
+	for _, change := range changes {
+		if change.IsMover(thresholdAbs, thresholdPercent) {
+			movers = append(movers, change)
+		}
+	}
+	sort.SliceStable(movers, func(i, j int) bool {
+		return math.Abs(movers[i].Change()) > math.Abs(movers[j].Change())
+	})
+	return movers
+}
+
+// formatPercent formats a change percentage, n/a if there was no previous value.
+func formatPercent(change ChangeRow) string {
+	percent, ok := change.ChangePercent()
+	if !ok {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.2f", percent)
+}
+
+// writeChangeCSV writes the changes with a leading header row, marking top movers.
+func writeChangeCSV(outfile *os.File, previousMonth string, currentMonth string, changes []ChangeRow, thresholdAbs float64, thresholdPercent float64) error {
+	writer := csv.NewWriter(outfile)
+	defer writer.Flush()
+	err := writer.Write([]string{"group", "accountId", "column", previousMonth, currentMonth, "change", "changePercent", "mover"})
+	if err != nil {
+		log.Printf("[writechangecsv] error writing csv header to file: %v ", err)
+		return err
+	}
+	for _, change := range changes {
+		accountID := change.AccountID
+		if accountID == "" {
+			accountID = "ALL"
+		}
+		mover := ""
+		if change.IsMover(thresholdAbs, thresholdPercent) {
+			mover = "MOVER"
+		}
+		err := writer.Write([]string{
+			change.Category,
+			accountID,
+			change.Column,
+			fmt.Sprintf("%f", change.Previous),
+			fmt.Sprintf("%f", change.Current),
+			fmt.Sprintf("%f", change.Change()),
+			formatPercent(change),
+			mover,
+		})
+		if err != nil {
+			log.Printf("[writechangecsv] error writing csv data to file: %v ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTopMovers writes the top movers to the report file.
+func writeTopMovers(reportfile *os.File, previousMonth string, currentMonth string, movers []ChangeRow) {
+	writeReport(reportfile, fmt.Sprintf("top movers from %s to %s: %d", previousMonth, currentMonth, len(movers)))
+	for _, mover := range movers {
+		scope := "group " + mover.Category
+		if mover.AccountID != "" {
+			scope = fmt.Sprintf("account %s (group %s)", mover.AccountID, mover.Category)
+		}
+		writeReport(reportfile, fmt.Sprintf("  %s, %s: %.2f -> %.2f (%+.2f, %s%%)", scope, mover.Column, mover.Previous, mover.Current, mover.Change(), formatPercent(mover)))
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestComputeChanges(t *testing.T) {
+	rows := []ReportRow{
+		historyTestRow("a", "2026-01", "111111111111", 100),
+		historyTestRow("a", "2026-02", "111111111111", 150),
+		historyTestRow("a", "2026-01", "222222222222", 50),
+		historyTestRow("a", "2026-02", "222222222222", 52),
+		historyTestRow("b", "2026-02", "333333333333", 500),
+	}
+	changes := computeChanges("2026-01", "2026-02", rows)
+	find := func(category string, accountID string, column string) ChangeRow {
+		for _, change := range changes {
+			if change.Category == category && change.AccountID == accountID && change.Column == column {
+				return change
+			}
+		}
+		t.Fatalf("no change for %s/%s/%s", category, accountID, column)
+		return ChangeRow{}
+	}
+	category := find("a", "", ColumnMachines)
+	if category.Previous != 150 || category.Current != 202 {
+		t.Errorf("unexpected category change: %v", category)
+	}
+	account := find("a", "111111111111", ColumnTotal)
+	if percent, ok := account.ChangePercent(); !ok || percent != 50 || account.Change() != 50 {
+		t.Errorf("unexpected account change: %v", account)
+	}
+	if changes[0].Category != "a" || changes[0].AccountID != "" {
+		t.Errorf("expected category rows first, got %v", changes[0])
+	}
+	movers := topMovers(changes, 40, 10)
+	if len(movers) == 0 || movers[0].Category != "b" || movers[0].Current != 500 {
+		t.Fatalf("expected new account to be the top mover, got %v", movers)
+	}
+	for _, mover := range movers {
+		if mover.AccountID == "222222222222" {
+			t.Errorf("expected small change not to be a mover, got %v", mover)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultCMBaseURL is the default base URL of the cost management API.
+const DefaultCMBaseURL = "https://console.redhat.com/api/cost-management/v1"
+
+// DefaultSSOTokenURL is the default SSO endpoint offline tokens are exchanged at.
+const DefaultSSOTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+
+// DefaultSSOClientID is the default client id used for exchanging offline tokens.
+const DefaultSSOClientID = "cloud-services"
+
+// tokenExpiryMargin is subtracted from the token lifetime to refresh tokens before they expire.
+const tokenExpiryMargin = 30 * time.Second
+
+// TokenSource retrieves access tokens from an SSO token endpoint, either by exchanging an
+// offline refresh token or by using service account client credentials. Tokens are cached
+// until shortly before they expire.
+type TokenSource struct {
+	httpClient   *http.Client
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	refreshToken string
+	mutex        sync.Mutex
+	accessToken  string
+	expiry       time.Time
+}
+
+// tokenResponse describes the response of the token endpoint.
+type tokenResponse struct {
+	AccessToken string  `json:"access_token"`
+	ExpiresIn   float64 `json:"expires_in"`
+}
+
+// NewTokenSource returns a new token source. If refreshToken is given, it is exchanged for
+// access tokens, otherwise the client credentials are used.
+func NewTokenSource(client *http.Client, tokenURL string, clientID string, clientSecret string, refreshToken string) (*TokenSource, error) {
+	if refreshToken == "" && clientSecret == "" {
+		return nil, errors.New("either an offline token or a client secret needs to be given")
+	}
+	ts := new(TokenSource)
+	ts.httpClient = client
+	ts.tokenURL = tokenURL
+	ts.clientID = clientID
+	ts.clientSecret = clientSecret
+	ts.refreshToken = refreshToken
+	return ts, nil
+}
+
+// Token returns a valid access token, requesting a new one if needed.
+func (t *TokenSource) Token() (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.accessToken != "" && time.Now().Before(t.expiry) {
+		return t.accessToken, nil
+	}
+	form := url.Values{}
+	form.Set("client_id", t.clientID)
+	if t.refreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", t.refreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_secret", t.clientSecret)
+	}
+	log.Printf("[token] requesting access token from %s", t.tokenURL)
+	resp, err := t.httpClient.PostForm(t.tokenURL, form)
+	if err != nil {
+		log.Printf("[token] error requesting access token: %v", err)
+		return "", err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("[token] error reading token response: %v", err)
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", &HTTPStatusError{StatusCode: resp.StatusCode, URL: t.tokenURL, Body: string(bodyBytes)}
+	}
+	token := new(tokenResponse)
+	err = json.Unmarshal(bodyBytes, token)
+	if err != nil {
+		log.Printf("[token] error parsing token response: %v", err)
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("token response does not contain an access token")
+	}
+	t.accessToken = token.AccessToken
+	t.expiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return t.accessToken, nil
+}
+
+// consoleURL returns the scheme and host of the given API base URL.
+func consoleURL(baseURL string) (string, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return "", fmt.Errorf("base url %s is not an absolute url", baseURL)
+	}
+	return parsed.Scheme + "://" + parsed.Host, nil
+}
+
+// consoleHost returns the host name of the given API base URL.
+func consoleHost(baseURL string) (string, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Hostname(), nil
+}
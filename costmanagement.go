@@ -8,12 +8,15 @@ import (
 	"log"
 	"math"
 	"net/http"
+	"strings"
 )
 
 // Response describes the toplevel data structure
 type Response struct {
 	Meta MetaSection   `json:"meta"`
 	Data []DataSection `json:"data"`
+	// Conversion describes the conversion of the values into the reporting currency
+	Conversion Conversion `json:"-"`
 }
 
 // MetaSection describes a child data structure
@@ -65,61 +68,91 @@ type ValueSection struct {
 
 // CMPuller implements the Cost Management query client.
 type CMPuller struct {
-	debug      bool
-	httpClient *http.Client
-	cookieMap  map[string]string
+	debug       bool
+	httpClient  *http.Client
+	baseURL     string
+	cookieMap   map[string]string
+	tokenSource *TokenSource
+	mapping     *ServiceMapping
+	retrier     *Retrier
+	converter   *CurrencyConverter
 }
 
-// NewCMPuller returns a new Cost Management client.
-func NewCMPuller(debug bool, client *http.Client, cookieMap map[string]string) *CMPuller {
+// NewCMPuller returns a new Cost Management client. Requests are authenticated with bearer
+// tokens from the token source if given, otherwise with the cookies.
+func NewCMPuller(debug bool, client *http.Client, baseURL string, cookieMap map[string]string, tokenSource *TokenSource, mapping *ServiceMapping, retrier *Retrier, converter *CurrencyConverter) *CMPuller {
 	cmp := new(CMPuller)
 	cmp.debug = debug
 	cmp.httpClient = client
+	cmp.baseURL = strings.TrimSuffix(baseURL, "/")
 	cmp.cookieMap = cookieMap
+	cmp.tokenSource = tokenSource
+	cmp.mapping = mapping
+	cmp.retrier = retrier
+	cmp.converter = converter
 	return cmp
 }
 
-// PullData retrieves a raw data set.
-func (c *CMPuller) PullData(accountID string) ([]byte, error) {
+// PullData retrieves a raw data set for the given period. The period must not cross month
+// boundaries. Requests failing with retryable errors are retried.
+func (c *CMPuller) PullData(accountID string, period DateRange) ([]byte, error) {
+	var bodyBytes []byte
+	err := c.retrier.Do("cost management pull "+accountID, func() (err error) {
+		bodyBytes, err = c.pullData(accountID, period)
+		return err
+	})
+	return bodyBytes, err
+}
+
+func (c *CMPuller) pullData(accountID string, period DateRange) ([]byte, error) {
 	// create request
-	req, err := http.NewRequest("GET", "https://cloud.redhat.com/api/cost-management/v1/reports/aws/costs/", nil)
+	req, err := http.NewRequest("GET", c.baseURL+"/reports/aws/costs/", nil)
 	if err != nil {
 		log.Printf("[pulldata] error creating request: %v ", err)
 		return nil, err
 	}
 	// add get params
 	q := req.URL.Query()
-	q.Add("filter[time_scope_units]", "month")
-	q.Add("filter[time_scope_value]", "-2")
+	q.Add("start_date", period.StartDay())
+	q.Add("end_date", period.End.AddDate(0, 0, -1).Format(dayFormat))
 	q.Add("filter[resolution]", "monthly")
 	q.Add("filter[account]", accountID)
 	q.Add("group_by[service]", "*")
 	req.URL.RawQuery = q.Encode()
-	// add cookies
-	for cookieKey, cookieValue := range c.cookieMap {
-		thisCookie := new(http.Cookie)
-		thisCookie.Name = cookieKey
-		thisCookie.Value = cookieValue
-		req.AddCookie(thisCookie)
+	// add authentication
+	if c.tokenSource != nil {
+		token, err := c.tokenSource.Token()
+		if err != nil {
+			log.Printf("[pulldata] error retrieving access token: %v ", err)
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		for cookieKey, cookieValue := range c.cookieMap {
+			thisCookie := new(http.Cookie)
+			thisCookie.Name = cookieKey
+			thisCookie.Value = cookieValue
+			req.AddCookie(thisCookie)
+		}
 	}
 	// set headers
-	req.Header.Set("authority", "cloud.redhat.com")
+	req.Header.Set("authority", req.URL.Host)
 	req.Header.Set("pragma", "no-cache")
 	req.Header.Set("cache-control", "no-cache")
 	req.Header.Set("accept", "application/json, text/plain, */*")
-	req.Header.Set("referer", "https://cloud.redhat.com/beta/cost-management/")
+	req.Header.Set("referer", req.URL.Scheme+"://"+req.URL.Host+"/beta/cost-management/")
 	// execute request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		log.Printf("[pulldata] error pulling data from service: %v ", err)
 		return nil, err
 	}
+	defer resp.Body.Close()
 	// check response
 	if resp.StatusCode != 200 {
 		log.Println("[pulldata] error pulling data from server")
 		bodyBytes, _ := ioutil.ReadAll(resp.Body)
-		bodyStr := string(bodyBytes)
-		return nil, fmt.Errorf("error fetching data from service, returned status %d, url was %s\nBody: %s", resp.StatusCode, req.URL.String(), bodyStr)
+		return nil, &HTTPStatusError{StatusCode: resp.StatusCode, URL: req.URL.String(), Body: string(bodyBytes)}
 	}
 	// read body
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
@@ -139,56 +172,83 @@ func (c *CMPuller) ParseResponse(response []byte) (*Response, error) {
 		log.Printf("[parseresponse] error parsing json: %v\n", err)
 		return nil, err
 	}
+	err = c.convertResponse(responseData)
+	if err != nil {
+		log.Printf("[parseresponse] error converting values: %v\n", err)
+		return nil, err
+	}
 	return responseData, nil
 }
 
-// NormalizeResponse normalizes a Response object data into report categories.
-func (c *CMPuller) NormalizeResponse(response *Response) ([]string, error) {
-	// format is:
-	// date, clusterId, accountId, PO, clusterType, usageType, product, infra, numberUsers, dataTransfer, machines, storage, keyMgmnt, registrar, dns, other, tax, refund
-	// init fields with pending flag
-	output := make([]string, 18)
-	for idx := range output {
-		output[idx] = "PENDING"
-	}
-	// infra is always AWS
-	output[7] = "AWS"
-	// set date - we use the first service entry
-	output[0] = response.Data[0].Date
-	// set clusterID
-	output[2] = response.Meta.Filter.Account[0]
-	// init cost values
-	output[9] = "0"
-	output[10] = "0"
-	output[11] = "0"
-	output[12] = "0"
-	output[13] = "0"
-	output[14] = "0"
-	output[15] = "0"
-	output[16] = "0"
-	output[17] = "0"
+// convertResponse converts all values of a response into the reporting currency using the
+// exchange rates of the month of the response data.
+func (c *CMPuller) convertResponse(response *Response) error {
+	if len(response.Data) == 0 {
+		return nil
+	}
+	month := response.Data[0].Date
+	convert := func(cost *TotalCostSection) (Conversion, error) {
+		conversion, err := c.converter.Conversion(cost.Unit, month)
+		if err != nil {
+			return conversion, err
+		}
+		cost.Value *= conversion.Rate
+		cost.Unit = conversion.Currency
+		return conversion, nil
+	}
+	conversion, err := convert(&response.Meta.Total.Cost.TotalCost)
+	if err != nil {
+		return err
+	}
+	response.Conversion = conversion
+	for _, data := range response.Data {
+		for _, service := range data.Services {
+			for i := range service.Values {
+				_, err := convert(&service.Values[i].Cost.TotalCost)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// NormalizeResponse normalizes a Response object data into report categories. Also returns
+// the services that have no column mapping and were added to the other column.
+func (c *CMPuller) NormalizeResponse(group string, daterange string, response *Response) (*ReportRow, map[string]float64, error) {
+	if len(response.Meta.Filter.Account) == 0 {
+		return nil, nil, errors.New("response has no account in meta filter")
+	}
+	if len(response.Data) == 0 {
+		return nil, nil, errors.New("response data is empty")
+	}
+	output := NewReportRow(group, daterange, response.Meta.Filter.Account[0])
 	// nomalize cost values
-	var otherVal float64 = 0
+	services := make(map[string]float64)
 	for _, service := range response.Data[0].Services {
-		switch service.Service {
-		case "AWSDataTransfer":
-			output[9] = fmt.Sprintf("%f", service.Values[0].Cost.TotalCost.Value)
-		case "AmazonEC2":
-			output[10] = fmt.Sprintf("%f", service.Values[0].Cost.TotalCost.Value)
-		case "AmazonS3":
-			output[11] = fmt.Sprintf("%f", service.Values[0].Cost.TotalCost.Value)
-		case "awskms":
-			output[12] = fmt.Sprintf("%f", service.Values[0].Cost.TotalCost.Value)
-		case "AmazonRoute53":
-			output[14] = fmt.Sprintf("%f", service.Values[0].Cost.TotalCost.Value)
-		default:
-			otherVal += service.Values[0].Cost.TotalCost.Value
+		if len(service.Values) == 0 {
+			return nil, nil, fmt.Errorf("service %s has no values section", service.Service)
 		}
+		services[service.Service] += service.Values[0].Cost.TotalCost.Value
 	}
-	// store other total
-	output[15] = fmt.Sprintf("%f", otherVal)
+	output.Services = services
+	output.Conversion = response.Conversion
+	columns, unmapped := c.mapping.Normalize(SourceCM, services)
+	output.AddColumns(columns)
 	// return result
-	return output, nil
+	return output, unmapped, nil
+}
+
+// CheckResponsePeriod checks that the response contains data for the month of the requested period.
+func (c *CMPuller) CheckResponsePeriod(period DateRange, response *Response) error {
+	expectedDate := period.Start.Format(monthFormat)
+	for _, data := range response.Data {
+		if data.Date != expectedDate {
+			return fmt.Errorf("response data date %s does not match requested period %s", data.Date, period)
+		}
+	}
+	return nil
 }
 
 // CheckResponseConsistency checks the response consistency with various checks. Returns the calculated total.
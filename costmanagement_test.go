@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// newCMTestServer returns a stand-in for the SSO token endpoint and the cost management api
+// serving the response fixture. Token requests are counted in tokenRequests.
+func newCMTestServer(t *testing.T, tokenRequests *int) *httptest.Server {
+	t.Helper()
+	response, err := ioutil.ReadFile(filepath.Join("testdata", "costmanagement_response.json"))
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		*tokenRequests++
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "offline" || r.FormValue("client_id") != DefaultSSOClientID {
+			http.Error(w, "invalid grant", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token": "access", "expires_in": 900}`))
+	})
+	mux.HandleFunc("/api/cost-management/v1/reports/aws/costs/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		query := r.URL.Query()
+		if query.Get("start_date") != "2026-01-01" || query.Get("end_date") != "2026-01-31" || query.Get("filter[account]") != "111111111111" {
+			http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		w.Write(response)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestCMPullDataWithToken(t *testing.T) {
+	tokenRequests := 0
+	server := newCMTestServer(t, &tokenRequests)
+	defer server.Close()
+	tokenSource, err := NewTokenSource(server.Client(), server.URL+"/token", DefaultSSOClientID, "", "offline")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	puller := NewCMPuller(false, server.Client(), server.URL+"/api/cost-management/v1/", nil, tokenSource, DefaultServiceMapping(), nil, nil)
+	period := mustParseDateRange(t, "2026-01", "")
+	for i := 0; i < 2; i++ {
+		raw, err := puller.PullData("111111111111", period)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		response, err := puller.ParseResponse(raw)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := puller.CheckResponsePeriod(period, response); err != nil {
+			t.Errorf("unexpected period error: %v", err)
+		}
+		total, err := puller.CheckResponseConsistency(AccountEntry{AccountID: "111111111111"}, response)
+		if err != nil || total != 115.5 {
+			t.Errorf("expected consistent total of 115.5, got %f (%v)", total, err)
+		}
+	}
+	if tokenRequests != 1 {
+		t.Errorf("expected access token to be cached, got %d token requests", tokenRequests)
+	}
+}
+
+func TestCMCheckResponsePeriod(t *testing.T) {
+	puller := NewCMPuller(false, nil, DefaultCMBaseURL, nil, nil, DefaultServiceMapping(), nil, nil)
+	response := &Response{Data: []DataSection{DataSection{Date: "2025-11"}}}
+	if err := puller.CheckResponsePeriod(mustParseDateRange(t, "2026-01", ""), response); err == nil {
+		t.Error("expected error for response of a different month")
+	}
+}
+
+func TestCMNormalizeResponse(t *testing.T) {
+	raw, err := ioutil.ReadFile(filepath.Join("testdata", "costmanagement_response.json"))
+	if err != nil {
+		t.Fatalf("error reading fixture: %v", err)
+	}
+	puller := NewCMPuller(false, nil, DefaultCMBaseURL, nil, nil, DefaultServiceMapping(), nil, nil)
+	response, err := puller.ParseResponse(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	row, unmapped, err := puller.NormalizeResponse("someGroup", "2026-01", response)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if row.AccountID != "111111111111" || row.Machines != 100 || row.Storage != 10 || row.Other != 5.5 {
+		t.Errorf("unexpected row: %v", *row)
+	}
+	if len(unmapped) != 1 || unmapped["AmazonSageMaker"] != 5.5 {
+		t.Errorf("expected SageMaker to be reported as unmapped, got %v", unmapped)
+	}
+}
+
+func TestCMNormalizeEmptyResponse(t *testing.T) {
+	puller := NewCMPuller(false, nil, DefaultCMBaseURL, nil, nil, DefaultServiceMapping(), nil, nil)
+	for _, raw := range []string{
+		`{"meta": {"filter": {"account": ["111111111111"]}}, "data": []}`,
+		`{"meta": {"filter": {"account": []}}, "data": [{"date": "2026-01", "services": []}]}`,
+		`{"meta": {"filter": {"account": ["111111111111"]}}, "data": [{"date": "2026-01", "services": [{"service": "AmazonEC2", "values": []}]}]}`,
+	} {
+		response, err := puller.ParseResponse([]byte(raw))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, _, err := puller.NormalizeResponse("someGroup", "2026-01", response); err == nil {
+			t.Errorf("expected error normalizing %s", raw)
+		}
+	}
+}
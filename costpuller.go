@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"encoding/csv"
 	"errors"
 	"flag"
 	"fmt"
@@ -14,6 +13,7 @@ import (
 	"os/user"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/zellyn/kooky"
@@ -25,8 +25,14 @@ type AccountEntry struct {
 	AccountID string `yaml:"accountid"`
 	Standardvalue float64	`yaml:"standardvalue"`
 	Deviationpercent int  `yaml:"deviationpercent"`
-	Category string `yaml:"category"`
+	Category string `yaml:"category,omitempty"`
 	Description string `yaml:"description"`
+	PO string `yaml:"po,omitempty"`
+	ClusterID string `yaml:"clusterid,omitempty"`
+	ClusterType string `yaml:"clustertype,omitempty"`
+	UsageType string `yaml:"usagetype,omitempty"`
+	Product string `yaml:"product,omitempty"`
+	NumberUsers string `yaml:"numberusers,omitempty"`
 }
 
 func main() {
@@ -36,22 +42,82 @@ func main() {
 	usr, _ := user.Current()
 	nowStr := time.Now().Format("20060102150405")
 	// configure flags
-	modePtr := flag.String("mode", "aws", "run mode, needs to be one of aws, cm or crosscheck")
+	modePtr := flag.String("mode", "aws", "run mode, needs to be one of aws, cm, crosscheck, change, forecast, daily, region, purchase, history or calibrate")
 	debugPtr := flag.Bool("debug", false, "outputs debug info")
 	awsWriteTagsPtr := flag.Bool("awswritetags", false, "write tags to AWS accounts (USE WITH CARE!)")
 	awsCheckTagsPtr := flag.Bool("checktags", false, "checks all AWS accounts available for correct tag setting.")
 	accountsFilePtr := flag.String("accounts", "accounts.yaml", "file to read accounts list from")
 	taggedAccountsPtr := flag.Bool("taggedaccounts", false, "use the AWS tags as account list source")
-	monthPtr := flag.String("month", "", "context month in format yyyy-mm, only for aws or crosscheck modes")
-	costTypePtr := flag.String("costtype", "UnblendedCost", "cost type to pull, only for aws or crosscheck modes, one of AmortizedCost, BlendedCost, NetAmortizedCost, NetUnblendedCost, NormalizedUsageAmount, UnblendedCost, and UsageQuantity")
+	monthPtr := flag.String("month", "", "context month in format yyyy-mm")
+	fromPtr := flag.String("from", "", "start of date range in format yyyy-mm or yyyy-mm-dd, alternative to --month")
+	toPtr := flag.String("to", "", "inclusive end of date range in format yyyy-mm or yyyy-mm-dd, defaults to --from")
+	batchPtr := flag.Bool("batch", false, "pull AWS data for all accounts with batched queries instead of per account queries, only for aws or crosscheck modes")
+	costTypePtr := flag.String("costtype", "UnblendedCost", "cost type to pull, one of AmortizedCost, BlendedCost, NetAmortizedCost, NetUnblendedCost, NormalizedUsageAmount, UnblendedCost, and UsageQuantity, the usage metrics NormalizedUsageAmount and UsageQuantity are only supported in aws mode")
+	cmURLPtr := flag.String("cmurl", DefaultCMBaseURL, "base url of the cost management api, only for cm or crosscheck modes")
+	cmTokenPtr := flag.String("cmtoken", "", "offline token for the cost management api, exchanged for access tokens at --ssourl, defaults to env CM_OFFLINE_TOKEN, only for cm or crosscheck modes")
+	cmClientIDPtr := flag.String("cmclientid", DefaultSSOClientID, "client id used for retrieving access tokens, only for cm or crosscheck modes")
+	cmClientSecretPtr := flag.String("cmclientsecret", "", "service account client secret, used for retrieving access tokens when no offline token is given, defaults to env CM_CLIENT_SECRET, only for cm or crosscheck modes")
+	ssoURLPtr := flag.String("ssourl", DefaultSSOTokenURL, "sso token endpoint for retrieving access tokens, only for cm or crosscheck modes")
 	cookiePtr := flag.String("cookie", "", "access cookie for cost management system in curl serialized format, only for cm or crosscheck modes")
 	readcookiePtr := flag.Bool("readcookie", true, "reads the cookie from the Chrome cookies database, only for cm or crosscheck modes")
 	cookieDbPtr := flag.String("cookiedb", fmt.Sprintf("%s/.config/google-chrome/Default/Cookies", usr.HomeDir), "path to Chrome cookies database file, only for cm or crosscheck modes")
+	concurrencyPtr := flag.Int("concurrency", 1, "number of accounts pulled in parallel, also used for pulling AWS account tags")
+	maxAttemptsPtr := flag.Int("maxattempts", DefaultMaxAttempts, "maximum number of attempts for requests failing with throttling, server errors or timeouts")
+	retryDelayPtr := flag.Duration("retrydelay", DefaultRetryDelay, "delay before the first retry of a failed request, doubled with every retry")
+	keepGoingPtr := flag.Bool("keepgoing", false, "continue with the remaining accounts if pulling an account fails, failed accounts are summarized at the end")
+	historyFilePtr := flag.String("history", "history.db", "history database file pulled data is stored in, set to empty to disable")
+	useHistoryPtr := flag.Bool("usehistory", false, "use data stored in the history database instead of pulling months again, only for aws mode")
+	sourcePtr := flag.String("source", SourceAWS, "data source to query, one of aws or cm, only for history and calibrate modes")
+	accountPtr := flag.String("account", "", "account id to query, only for history mode")
+	categoryPtr := flag.String("category", "", "category to query, only for history mode")
+	moverAbsPtr := flag.Float64("moverabs", 100, "minimum absolute change for top movers, only for change mode")
+	moverPercentPtr := flag.Float64("moverpercent", 10, "minimum change in percent for top movers, only for change mode")
+	calibratePtr := flag.Int("calibrate", 0, "number of previous months in the history database the standard values are calibrated from, replaces the standard values of the accounts file for the deviation check if set, also used for calibrate mode (default 6 months there)")
+	calibrateMethodPtr := flag.String("calibratemethod", CalibrateMedian, "method for calibrating the standard value, one of mean or median")
+	calibrateSigmaPtr := flag.Float64("calibratesigma", 2, "allowed deviation from the calibrated standard value in standard deviations")
+	anomaliesPtr := flag.Int("anomalies", 0, "number of previous months in the history database service costs are compared with for detecting anomalies, disabled if not set, only for aws, crosscheck and change modes")
+	anomalySigmaPtr := flag.Float64("anomalysigma", 3, "deviation in standard deviations a service cost is reported as anomaly from")
+	anomalyMinPtr := flag.Float64("anomalymin", 10, "minimum absolute deviation of a service cost reported as anomaly")
+	anomalyNewPtr := flag.Float64("anomalynew", 100, "minimum cost of services not billed in the previous months reported as anomaly")
+	predictionIntervalPtr := flag.Int64("predictioninterval", DefaultPredictionInterval, "confidence level of the forecast interval in percent, one of 80 or 95, only for forecast mode")
+	tagPtr := flag.String("tag", "", "cost allocation tag key the AWS cost is grouped by instead of the report columns, only for aws mode")
+	tagServicesPtr := flag.Bool("tagservices", false, "split the cost of each tag value by service, only with --tag")
+	drillDownPtr := flag.Bool("drilldown", false, "reclassify the cost of AWS services by usage type with the usagetypes section of the mapping, eg. EBS volumes of EC2 - Other into storage, only for aws and change modes")
+	regionServicesPtr := flag.Bool("regionservices", false, "split the cost of each region by service, only for region mode")
+	currencyPtr := flag.String("currency", DefaultCurrency, "reporting currency all amounts are converted to")
+	ratesFilePtr := flag.String("rates", "", "file to read the monthly exchange rates for converting amounts to the reporting currency from")
+	mappingFilePtr := flag.String("mapping", "", "file to read the service to column mapping from, uses the built-in mapping if not given")
 	csvfilePtr := flag.String("csv", fmt.Sprintf("output-%s.csv", nowStr), "output file for csv data")
 	reportfilePtr := flag.String("report", fmt.Sprintf("report-%s.txt", nowStr), "output file for data consistency report")
 	flag.Parse()
+	// secrets are read from the environment after parsing, so usage output does not print them
+	if *cmTokenPtr == "" {
+		*cmTokenPtr = os.Getenv("CM_OFFLINE_TOKEN")
+	}
+	if *cmClientSecretPtr == "" {
+		*cmClientSecretPtr = os.Getenv("CM_CLIENT_SECRET")
+	}
+	if IsUsageMetric(*costTypePtr) && *modePtr != "aws" && *modePtr != "history" {
+		log.Fatalf("[main] usage metric %s is only supported in aws mode", *costTypePtr)
+	}
+	if *tagPtr != "" && (*modePtr != "aws" || IsUsageMetric(*costTypePtr)) {
+		log.Fatal("[main] grouping by tag is only supported in aws mode with cost types")
+	}
+	// load service mapping
+	mapping, err := LoadServiceMapping(*mappingFilePtr)
+	if err != nil {
+		log.Fatalf("[main] error loading service mapping: %v", err)
+	}
+	// load exchange rates
+	rates, err := LoadExchangeRates(*ratesFilePtr)
+	if err != nil {
+		log.Fatalf("[main] error loading exchange rates: %v", err)
+	}
+	converter := NewCurrencyConverter(rates, *currencyPtr)
+	// create retrier, retries are logged to the report once it is opened
+	retrier := NewRetrier(*maxAttemptsPtr, *retryDelayPtr)
 	// create aws puller instance
-	awsPuller := NewAWSPuller(*debugPtr)
+	awsPuller := NewAWSPuller(*debugPtr, mapping, retrier, converter)
 	if *awsWriteTagsPtr {
 		// we pull accounts from file
 		accounts, err := getAccountSetsFromFile(*accountsFilePtr)
@@ -66,21 +132,101 @@ func main() {
 	}
 	if *awsCheckTagsPtr {
 		log.Println("[main] checking tags on AWS")
-		_, err := getAccountSetsFromAWS(awsPuller)
+		_, err := getAccountSetsFromAWS(awsPuller, *concurrencyPtr)
 		if err != nil {
 			log.Fatalf("[main] error getting accounts list: %v", err)
 		}
 		os.Exit(0)
 	}
+	// open history store
+	var history *HistoryStore
+	if *historyFilePtr != "" {
+		history, err = OpenHistoryStore(*historyFilePtr, *currencyPtr)
+		if err != nil {
+			log.Fatalf("[main] error opening history database: %v", err)
+		}
+		defer history.Close()
+	}
+	if *modePtr == "history" {
+		if history == nil {
+			log.Fatal("[main] history mode requested, but no history database given (use --history=file)")
+		}
+		err = queryHistory(history, *csvfilePtr, *sourcePtr, *costTypePtr, *categoryPtr, *accountPtr, *monthPtr, *fromPtr, *toPtr)
+		if err != nil {
+			log.Fatalf("[main] error querying history: %v", err)
+		}
+		log.Println("[main] operation done")
+		return
+	}
+	// create calibrators for deviation checks
+	var awsCalibrator, cmCalibrator *Calibrator
+	if *calibratePtr > 0 || *modePtr == "calibrate" {
+		if history == nil {
+			log.Fatal("[main] calibration requested, but no history database given (use --history=file)")
+		}
+		calibrateMonths := *calibratePtr
+		if *modePtr == "calibrate" && calibrateMonths == 0 {
+			calibrateMonths = DefaultCalibrationMonths
+			log.Printf("[main] no number of months given for calibrate mode (use --calibrate=n), using the previous %d months", calibrateMonths)
+		}
+		awsCalibrator, err = NewCalibrator(history, SourceAWS, *costTypePtr, calibrateMonths, *calibrateMethodPtr, *calibrateSigmaPtr)
+		if err != nil {
+			log.Fatalf("[main] error creating calibrator: %v", err)
+		}
+		cmCalibrator, err = NewCalibrator(history, SourceCM, "", calibrateMonths, *calibrateMethodPtr, *calibrateSigmaPtr)
+		if err != nil {
+			log.Fatalf("[main] error creating calibrator: %v", err)
+		}
+	}
+	// create anomaly detector
+	var detector *AnomalyDetector
+	if *anomaliesPtr > 0 {
+		if history == nil {
+			log.Fatal("[main] anomaly detection requested, but no history database given (use --history=file)")
+		}
+		detector, err = NewAnomalyDetector(history, SourceAWS, *costTypePtr, *anomaliesPtr, *anomalySigmaPtr, *anomalyMinPtr, *anomalyNewPtr)
+		if err != nil {
+			log.Fatalf("[main] error creating anomaly detector: %v", err)
+		}
+	}
+	if *modePtr == "calibrate" {
+		accounts, err := getAccountSetsFromFile(*accountsFilePtr)
+		if err != nil {
+			log.Fatalf("[main] error getting accounts list: %v", err)
+		}
+		before := time.Now()
+		if *monthPtr != "" {
+			month, err := ParseDateRange(*monthPtr, "", "")
+			if err != nil {
+				log.Fatalf("[main] calibrate mode requested, but no valid month given (use --month=yyyy-mm): %v", err)
+			}
+			before = month.Start
+		}
+		calibrator := awsCalibrator
+		if *sourcePtr == SourceCM {
+			calibrator = cmCalibrator
+		}
+		err = writeCalibratedAccounts(calibrator, *accountsFilePtr, accounts, before)
+		if err != nil {
+			log.Fatalf("[main] error writing calibrated accounts: %v", err)
+		}
+		log.Println("[main] operation done")
+		return
+	}
 	// open output files
 	log.Printf("[main] using csv output file %s\n", *csvfilePtr)
 	log.Printf("[main] using report output file %s\n", *reportfilePtr)
 	// create data holder
-	csvData := make([][]string, 0)
+	csvData := make([]ReportRow, 0)
+	failures := []PullFailure{}
+	// mode specific results of the accounts for modes with their own output
+	results := NewAccountResults()
+	// each mode sets how its rows are written and whether they are stored in the history
+	var output modeOutput
 	// get account lists
 	var accounts map[string][]AccountEntry
 	if *taggedAccountsPtr {
-		accounts, err = getAccountSetsFromAWS(awsPuller)
+		accounts, err = getAccountSetsFromAWS(awsPuller, *concurrencyPtr)
 	} else {
 		// we pull accounts from file
 		accounts, err = getAccountSetsFromFile(*accountsFilePtr)
@@ -88,10 +234,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("[main] error getting accounts list: %v", err)
 	}
-	sortedAccountKeys := sortedKeys(accounts)
-	if err != nil {
-		log.Fatalf("[main] error unmarshalling accounts file: %v", err)
-	}
 	// open csv output file
 	outfile, err := os.Create(*csvfilePtr)
 	if err != nil {
@@ -104,88 +246,345 @@ func main() {
 		log.Fatalf("[main] error creating report file: %v", err)
 	}
 	defer reportfile.Close()
+	retrier.OnRetry = func(operation string, attempt int, delay time.Duration, err error) {
+		writeReport(reportfile, fmt.Sprintf("retry: attempt %d of %d for %s failed, retrying in %s: %v", attempt, retrier.MaxAttempts, operation, delay, err))
+	}
 	// check for run mode
 	switch *modePtr {
 	case "aws":
 		log.Println("[main] note: using credentials and account from env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for aws pull")
-		if *monthPtr == "" || *costTypePtr == "" {
-			log.Fatal("[main] aws mode requested, but no month and/or costtype given (use --month=yyyy-mm, --costtype=type)")
-		}
-		for _, accountKey := range(sortedAccountKeys) {
-			group := accountKey
-			accountList := accounts[accountKey]
-			//csvData = appendCSVHeader(csvData, group)
-			for _, account := range(accountList) {
-				log.Printf("[main] pulling data for account %s (group %s)\n", account.AccountID, group)			
-				csvData, _, err = pullAWS(*awsPuller, reportfile, group, account, csvData, *monthPtr, *costTypePtr)
+		dateRange, err := ParseDateRange(*monthPtr, *fromPtr, *toPtr)
+		if err != nil || *costTypePtr == "" {
+			log.Fatalf("[main] aws mode requested, but no valid month or date range and/or costtype given (use --month=yyyy-mm or --from=yyyy-mm[-dd] --to=yyyy-mm[-dd], --costtype=type): %v", err)
+		}
+		if IsUsageMetric(*costTypePtr) {
+			log.Printf("[main] pulling usage metric %s by service and usage type", *costTypePtr)
+			csvData, failures = runAccounts(accounts, *keepGoingPtr, *concurrencyPtr, dateRange, func(group string, account AccountEntry) ([]ReportRow, error) {
+				return pullAccountResults(results, group, account, dateRange, "usage", func() (interface{}, error) {
+					return awsPuller.PullUsage(account.AccountID, dateRange, *costTypePtr)
+				})
+			})
+			// usage is not stored
+			output = modeOutput{write: func(outfile *os.File, rows []ReportRow) error {
+				return writeUsageCSV(outfile, *costTypePtr, rows, results)
+			}}
+			break
+		}
+		if *tagPtr != "" {
+			log.Printf("[main] pulling cost by tag %s", *tagPtr)
+			csvData, failures = runAccounts(accounts, *keepGoingPtr, *concurrencyPtr, dateRange, func(group string, account AccountEntry) ([]ReportRow, error) {
+				return pullAccountResults(results, group, account, dateRange, "cost by tag "+*tagPtr, func() (interface{}, error) {
+					return awsPuller.PullTags(account.AccountID, dateRange, *costTypePtr, *tagPtr, *tagServicesPtr)
+				})
+			})
+			// tag costs are not stored
+			output = modeOutput{write: func(outfile *os.File, rows []ReportRow) error {
+				return writeTagCSV(outfile, *tagPtr, *tagServicesPtr, rows, results)
+			}}
+			break
+		}
+		if *batchPtr {
+			err = awsPuller.PrefetchData(accountIDs(accounts), dateRange, *costTypePtr)
+			if err != nil {
+				log.Fatalf("[main] error prefetching data: %v", err)
+			}
+		}
+		csvData, failures = runAccounts(accounts, *keepGoingPtr, *concurrencyPtr, dateRange, func(group string, account AccountEntry) ([]ReportRow, error) {
+			return pullAWSOrStored(*awsPuller, reportfile, awsCalibrator, detector, *drillDownPtr, history, *useHistoryPtr, group, account, dateRange, *costTypePtr)
+		})
+		output = modeOutput{write: writeCSV, historySource: awsHistorySource(*drillDownPtr)}
+	case "change":
+		log.Println("[main] note: using credentials and account from env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for aws pull")
+		currentRange, err := ParseDateRange(*monthPtr, "", "")
+		if err != nil || *costTypePtr == "" {
+			log.Fatalf("[main] change mode requested, but no valid month and/or costtype given (use --month=yyyy-mm, --costtype=type): %v", err)
+		}
+		previousRange := DateRange{Start: currentRange.Start.AddDate(0, -1, 0), End: currentRange.Start}
+		bothRange := DateRange{Start: previousRange.Start, End: currentRange.End}
+		log.Printf("[main] comparing %s to %s, using stored data where available", currentRange, previousRange)
+		csvData, failures = runAccounts(accounts, *keepGoingPtr, *concurrencyPtr, bothRange, func(group string, account AccountEntry) ([]ReportRow, error) {
+			rows := []ReportRow{}
+			for _, monthRange := range []DateRange{previousRange, currentRange} {
+				monthRows, err := pullAWSOrStored(*awsPuller, reportfile, awsCalibrator, detector, *drillDownPtr, history, true, group, account, monthRange, *costTypePtr)
+				rows = append(rows, monthRows...)
 				if err != nil {
-					log.Fatalf("[main] error pulling data: %v", err)
+					return rows, err
 				}
 			}
+			return rows, nil
+		})
+		changes := computeChanges(previousRange.String(), currentRange.String(), csvData)
+		writeTopMovers(reportfile, previousRange.String(), currentRange.String(), topMovers(changes, *moverAbsPtr, *moverPercentPtr))
+		output = modeOutput{
+			write: func(outfile *os.File, rows []ReportRow) error {
+				return writeChangeCSV(outfile, previousRange.String(), currentRange.String(), changes, *moverAbsPtr, *moverPercentPtr)
+			},
+			historySource: awsHistorySource(*drillDownPtr),
+		}
+	case "forecast":
+		log.Println("[main] note: using credentials and account from env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for aws pull")
+		if *costTypePtr == "" {
+			log.Fatal("[main] forecast mode requested, but no costtype given (use --costtype=type)")
+		}
+		now := time.Now().UTC()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		month := DateRange{Start: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)}
+		month.End = month.Start.AddDate(0, 1, 0)
+		log.Printf("[main] forecasting %s from %s", month, today.Format(dayFormat))
+		csvData, failures = runAccounts(accounts, *keepGoingPtr, *concurrencyPtr, month, func(group string, account AccountEntry) ([]ReportRow, error) {
+			return pullForecast(*awsPuller, reportfile, awsCalibrator, results, group, account, month, today, *costTypePtr, *predictionIntervalPtr)
+		})
+		// forecasts are not stored
+		output = modeOutput{write: func(outfile *os.File, rows []ReportRow) error {
+			return writeForecastCSV(outfile, rows, results)
+		}}
+	case "daily":
+		log.Println("[main] note: using credentials and account from env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for aws pull")
+		if *costTypePtr == "" {
+			log.Fatal("[main] daily mode requested, but no costtype given (use --costtype=type)")
+		}
+		now := time.Now().UTC()
+		dailyMonth := DateRange{Start: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)}
+		dailyMonth.End = dailyMonth.Start.AddDate(0, 1, 0)
+		if *monthPtr != "" {
+			dailyMonth, err = ParseDateRange(*monthPtr, "", "")
+			if err != nil {
+				log.Fatalf("[main] daily mode requested, but no valid month given (use --month=yyyy-mm): %v", err)
+			}
+		}
+		dailyUntil := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		if dailyUntil.After(dailyMonth.End) {
+			dailyUntil = dailyMonth.End
+		}
+		if !dailyUntil.After(dailyMonth.Start) {
+			log.Fatalf("[main] daily mode requested, but no days of %s are available yet", dailyMonth)
+		}
+		log.Printf("[main] pulling daily costs for %s until %s", dailyMonth, dailyUntil.Format(dayFormat))
+		csvData, failures = runAccounts(accounts, *keepGoingPtr, *concurrencyPtr, dailyMonth, func(group string, account AccountEntry) ([]ReportRow, error) {
+			return pullDaily(*awsPuller, reportfile, awsCalibrator, results, group, account, dailyMonth, dailyUntil, *costTypePtr)
+		})
+		// partial months are not stored
+		output = modeOutput{write: func(outfile *os.File, rows []ReportRow) error {
+			return writeDailyCSV(outfile, dailyMonth, int(dailyUntil.Sub(dailyMonth.Start).Hours()/24), rows, results)
+		}}
+	case "region":
+		log.Println("[main] note: using credentials and account from env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for aws pull")
+		dateRange, err := ParseDateRange(*monthPtr, *fromPtr, *toPtr)
+		if err != nil || *costTypePtr == "" {
+			log.Fatalf("[main] region mode requested, but no valid month or date range and/or costtype given (use --month=yyyy-mm or --from=yyyy-mm[-dd] --to=yyyy-mm[-dd], --costtype=type): %v", err)
+		}
+		csvData, failures = runAccounts(accounts, *keepGoingPtr, *concurrencyPtr, dateRange, func(group string, account AccountEntry) ([]ReportRow, error) {
+			return pullAccountResults(results, group, account, dateRange, "cost by region", func() (interface{}, error) {
+				return awsPuller.PullRegions(account.AccountID, dateRange, *costTypePtr, *regionServicesPtr)
+			})
+		})
+		// region costs are not stored
+		output = modeOutput{write: func(outfile *os.File, rows []ReportRow) error {
+			return writeRegionCSV(outfile, *regionServicesPtr, rows, results)
+		}}
+	case "purchase":
+		log.Println("[main] note: using credentials and account from env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for aws pull")
+		dateRange, err := ParseDateRange(*monthPtr, *fromPtr, *toPtr)
+		if err != nil || *costTypePtr == "" {
+			log.Fatalf("[main] purchase mode requested, but no valid month or date range and/or costtype given (use --month=yyyy-mm or --from=yyyy-mm[-dd] --to=yyyy-mm[-dd], --costtype=type): %v", err)
+		}
+		csvData, failures = runAccounts(accounts, *keepGoingPtr, *concurrencyPtr, dateRange, func(group string, account AccountEntry) ([]ReportRow, error) {
+			return pullPurchase(*awsPuller, reportfile, awsCalibrator, detector, results, group, account, dateRange, *costTypePtr)
+		})
+		// the rows are pulled without drill down
+		output = modeOutput{
+			write: func(outfile *os.File, rows []ReportRow) error {
+				return writePurchaseCSV(outfile, rows, results)
+			},
+			historySource: SourceAWS,
 		}
 	case "cm":
-		cookie, err := retrieveCookie(*cookiePtr, *readcookiePtr, *cookieDbPtr)
+		dateRange, err := ParseDateRange(*monthPtr, *fromPtr, *toPtr)
 		if err != nil {
-			log.Fatalf("[main] error retrieving cookie: %v", err)
-		}
-		httpClient := &http.Client{}
-		cmPuller := NewCMPuller(*debugPtr, httpClient, cookie)
-		for _, accountKey := range(sortedAccountKeys) {
-			group := accountKey
-			accountList := accounts[accountKey]
-			//csvData = appendCSVHeader(csvData, group)
-			for _, account := range(accountList) {
-				log.Printf("[main] pulling data for account %s (group %s)\n", account.AccountID, group)			
-				csvData, _, err = pullCostManagement(*cmPuller, reportfile, account, csvData, *monthPtr)
-				if err != nil {
-					log.Fatalf("[main] error pulling data: %v", err)
-				}
-			}
+			log.Fatalf("[main] cm mode requested, but no valid month or date range given (use --month=yyyy-mm or --from=yyyy-mm[-dd] --to=yyyy-mm[-dd]): %v", err)
+		}
+		cmPuller, err := createCMPuller(*debugPtr, mapping, retrier, converter, *cmURLPtr, *ssoURLPtr, *cmClientIDPtr, *cmClientSecretPtr, *cmTokenPtr, *cookiePtr, *readcookiePtr, *cookieDbPtr)
+		if err != nil {
+			log.Fatalf("[main] error creating cost management client: %v", err)
 		}
+		csvData, failures = runAccounts(accounts, *keepGoingPtr, *concurrencyPtr, dateRange, func(group string, account AccountEntry) ([]ReportRow, error) {
+			rows, _, err := pullCostManagement(*cmPuller, reportfile, cmCalibrator, group, account, []ReportRow{}, dateRange)
+			return rows, err
+		})
+		output = modeOutput{write: writeCSV, historySource: SourceCM}
 	case "crosscheck":
 		log.Println("[main] note: using credentials and account from env AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY for aws pull")
-		if *monthPtr == "" || *costTypePtr == "" {
-			log.Fatal("[main] aws mode requested, but no month and/or costtype given (use --month=yyyy-mm, --costtype=type)")
+		dateRange, err := ParseDateRange(*monthPtr, *fromPtr, *toPtr)
+		if err != nil || *costTypePtr == "" {
+			log.Fatalf("[main] aws mode requested, but no valid month or date range and/or costtype given (use --month=yyyy-mm or --from=yyyy-mm[-dd] --to=yyyy-mm[-dd], --costtype=type): %v", err)
 		}
-		cookie, err := retrieveCookie(*cookiePtr, *readcookiePtr, *cookieDbPtr)
-		if err != nil {
-			log.Fatalf("[main] error retrieving cookie: %v", err)
-		}
-		httpClient := &http.Client{}
-		cmPuller := NewCMPuller(*debugPtr, httpClient, cookie)
-		for _, accountKey := range(sortedAccountKeys) {
-			group := accountKey
-			accountList := accounts[accountKey]
-			//csvData = appendCSVHeader(csvData, group)
-			for _, account := range(accountList) {
-				log.Printf("[main] pulling data for account %s (group %s)\n", account.AccountID, group)
-				var totalAWS float64
-				_, totalAWS, err = pullAWS(*awsPuller, reportfile, group, account, nil, *monthPtr, *costTypePtr)
-				if err != nil {
-					log.Fatalf("[main] error pulling data: %v", err)
-				}
-				var totalCM float64
-				csvData, totalCM, err = pullCostManagement(*cmPuller, reportfile, account, csvData, *monthPtr)
-				if err != nil {
-					log.Fatalf("[main] error pulling data: %v", err)
-				}
-				// check if totals from AWS and CM are consistent
-				if math.Round(totalAWS*100)/100 != math.Round(totalCM*100)/100 {
-					log.Printf("[main] error checking consistency of totals from AWS and CM for account %s: aws = %f; cm = %f", account.AccountID, totalAWS, totalCM)
-					writeReport(reportfile, fmt.Sprintf("%s: error checking consistency of totals from AWS and CM: aws = %f; cm = %f", account.AccountID, totalAWS, totalCM))
-				}
+		if *batchPtr {
+			err = awsPuller.PrefetchData(accountIDs(accounts), dateRange, *costTypePtr)
+			if err != nil {
+				log.Fatalf("[main] error prefetching data: %v", err)
 			}
 		}
+		cmPuller, err := createCMPuller(*debugPtr, mapping, retrier, converter, *cmURLPtr, *ssoURLPtr, *cmClientIDPtr, *cmClientSecretPtr, *cmTokenPtr, *cookiePtr, *readcookiePtr, *cookieDbPtr)
+		if err != nil {
+			log.Fatalf("[main] error creating cost management client: %v", err)
+		}
+		csvData, failures = runAccounts(accounts, *keepGoingPtr, *concurrencyPtr, dateRange, func(group string, account AccountEntry) ([]ReportRow, error) {
+			_, totalAWS, err := pullAWS(*awsPuller, reportfile, awsCalibrator, detector, false, group, account, nil, dateRange, *costTypePtr)
+			if err != nil {
+				return nil, err
+			}
+			rows, totalCM, err := pullCostManagement(*cmPuller, reportfile, cmCalibrator, group, account, []ReportRow{}, dateRange)
+			if err != nil {
+				return rows, err
+			}
+			// check if totals from AWS and CM are consistent
+			if math.Round(totalAWS*100)/100 != math.Round(totalCM*100)/100 {
+				log.Printf("[main] error checking consistency of totals from AWS and CM for account %s: aws = %f; cm = %f", account.AccountID, totalAWS, totalCM)
+				writeReport(reportfile, fmt.Sprintf("%s: error checking consistency of totals from AWS and CM: aws = %f; cm = %f", account.AccountID, totalAWS, totalCM))
+			}
+			return rows, nil
+		})
+		// crosscheck outputs the rows from cost management
+		output = modeOutput{write: writeCSV, historySource: SourceCM}
+	default:
+		log.Fatalf("[main] unknown mode %s", *modePtr)
 	}
 	// write data to csv
-	err = writeCSV(outfile, csvData)
+	err = output.write(outfile, csvData)
 	if err != nil {
 		log.Fatalf("[main] error writing to output file: %v", err)
 	}
+	// store pulled data
+	if history != nil && output.historySource != "" {
+		run := HistoryRun{
+			ID:       nowStr,
+			Time:     time.Now(),
+			Mode:     *modePtr,
+			CostType: *costTypePtr,
+			Range:    historyRange(*monthPtr, *fromPtr, *toPtr),
+			Rows:     len(csvData),
+			Failures: len(failures),
+		}
+		if output.historySource == SourceCM {
+			run.CostType = ""
+		}
+		err = history.PutRun(run, output.historySource, csvData)
+		if err != nil {
+			log.Fatalf("[main] error storing data in history database: %v", err)
+		}
+		log.Printf("[main] stored run %s in history database %s", run.ID, *historyFilePtr)
+	}
+	// summarize failed accounts
+	if len(failures) > 0 {
+		writeFailureSummary(reportfile, failures)
+		outfile.Close()
+		reportfile.Close()
+		log.Fatalf("[main] operation done with %d failed accounts", len(failures))
+	}
 	// done
 	log.Println("[main] operation done")
 }
 
+// modeOutput describes how the rows of a run mode are written and stored.
+type modeOutput struct {
+	// write writes the rows to the csv output file.
+	write func(outfile *os.File, rows []ReportRow) error
+	// historySource is the source the rows are stored as in the history database, the rows
+	// are not stored if empty.
+	historySource string
+}
+
+// awsHistorySource returns the history source of the rows pulled from AWS. Rows reclassified
+// by usage type are not stored as they are not comparable to other rows.
+func awsHistorySource(drillDown bool) string {
+	if drillDown {
+		return ""
+	}
+	return SourceAWS
+}
+
+// storedRows returns the stored rows for all months of the date range, or nil if any month is
+// not stored.
+func storedRows(history *HistoryStore, source string, costType string, account AccountEntry, dateRange DateRange) ([]ReportRow, error) {
+	rows := []ReportRow{}
+	for _, period := range dateRange.Months() {
+		record, err := history.Get(source, costType, account.AccountID, period.String())
+		if err != nil {
+			return nil, err
+		}
+		if record == nil {
+			return nil, nil
+		}
+		row := record.Row
+		row.Services = record.Services
+		row.FromHistory = true
+		rows = append(rows, row)
+	}
+	log.Printf("[storedRows] using stored data for account %s from history", account.AccountID)
+	return rows, nil
+}
+
+// pullAWSOrStored returns the stored rows if useHistory is set and all months of the date
+// range are stored, otherwise the rows are pulled from AWS. Stored rows are not reclassified
+// by usage type, so they are not used with drill down.
+func pullAWSOrStored(awsPuller AWSPuller, reportfile *os.File, calibrator *Calibrator, detector *AnomalyDetector, drillDown bool, history *HistoryStore, useHistory bool, group string, account AccountEntry, dateRange DateRange, costType string) ([]ReportRow, error) {
+	if useHistory && history != nil && !drillDown {
+		rows, err := storedRows(history, SourceAWS, costType, account, dateRange)
+		if err != nil || rows != nil {
+			return rows, err
+		}
+	}
+	rows, _, err := pullAWS(awsPuller, reportfile, calibrator, detector, drillDown, group, account, []ReportRow{}, dateRange, costType)
+	return rows, err
+}
+
+// historyRange returns the date range given on the command line for the run metadata.
+func historyRange(month string, from string, to string) string {
+	dateRange, err := ParseDateRange(month, from, to)
+	if err != nil {
+		return ""
+	}
+	return dateRange.String()
+}
+
+// queryHistory writes the stored records matching the given filters to the csv file.
+func queryHistory(history *HistoryStore, csvFile string, source string, costType string, category string, accountID string, month string, from string, to string) error {
+	if month != "" {
+		from = month
+		to = month
+	}
+	fromBound, err := historyPeriodBound(from)
+	if err != nil {
+		return err
+	}
+	toBound, err := historyPeriodBound(to)
+	if err != nil {
+		return err
+	}
+	if source == SourceCM {
+		// cost management data has no cost type
+		costType = ""
+	}
+	records, err := history.Query(HistoryFilter{
+		Source:    source,
+		CostType:  costType,
+		Category:  category,
+		AccountID: accountID,
+		From:      fromBound,
+		To:        toBound,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("[queryHistory] found %d stored records, writing to %s", len(records), csvFile)
+	outfile, err := os.Create(csvFile)
+	if err != nil {
+		return err
+	}
+	defer outfile.Close()
+	return writeHistoryCSV(outfile, records)
+}
+
 func sortedKeys(m map[string][]AccountEntry) ([]string) {
 	keys := make([]string, len(m))
 	i := 0
@@ -197,7 +596,35 @@ func sortedKeys(m map[string][]AccountEntry) ([]string) {
 	return keys
 }
 
-func retrieveCookie(cookie string, readcookie bool, cookieDbFile string) (map[string]string, error) {
+func accountIDs(m map[string][]AccountEntry) ([]string) {
+	ids := []string{}
+	for _, accountKey := range sortedKeys(m) {
+		for _, account := range m[accountKey] {
+			ids = append(ids, account.AccountID)
+		}
+	}
+	return ids
+}
+
+func createCMPuller(debug bool, mapping *ServiceMapping, retrier *Retrier, converter *CurrencyConverter, baseURL string, tokenURL string, clientID string, clientSecret string, offlineToken string, cookie string, readcookie bool, cookieDbFile string) (*CMPuller, error) {
+	httpClient := &http.Client{}
+	if offlineToken != "" || clientSecret != "" {
+		log.Printf("[createCMPuller] using token authentication for %s", baseURL)
+		tokenSource, err := NewTokenSource(httpClient, tokenURL, clientID, clientSecret, offlineToken)
+		if err != nil {
+			return nil, err
+		}
+		return NewCMPuller(debug, httpClient, baseURL, nil, tokenSource, mapping, retrier, converter), nil
+	}
+	log.Printf("[createCMPuller] using cookie authentication for %s", baseURL)
+	cookieMap, err := retrieveCookie(baseURL, cookie, readcookie, cookieDbFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewCMPuller(debug, httpClient, baseURL, cookieMap, nil, mapping, retrier, converter), nil
+}
+
+func retrieveCookie(baseURL string, cookie string, readcookie bool, cookieDbFile string) (map[string]string, error) {
 	if cookie != "" {
 		// cookie is given on the cli in CURL format
 		log.Println("[retrieveCookie] retrieving cookies from cli")
@@ -206,11 +633,19 @@ func retrieveCookie(cookie string, readcookie bool, cookieDbFile string) (map[st
 		// cookie is to be read from Chrome's cookie database
 		log.Println("[retrieveCookie] retrieving cookies from Chrome database")
 		// wait for user to login
-		fmt.Print("ACTION REQUIRED: please login to https://cloud.redhat.com/beta/cost-management/aws using your Chrome browser. Hit Enter when done.")
+		console, err := consoleURL(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		host, err := consoleHost(baseURL)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("ACTION REQUIRED: please login to %s/beta/cost-management/aws using your Chrome browser. Hit Enter when done.", console)
 		scanner := bufio.NewScanner(os.Stdin)
 		scanner.Scan()
 		fmt.Println("Thanks! Now retrieving cookies from Chrome..")
-		cookiesCRH, err := kooky.ReadChromeCookies(cookieDbFile, "cloud.redhat.com", "", time.Time{})
+		cookiesCRH, err := kooky.ReadChromeCookies(cookieDbFile, host, "", time.Time{})
 		if err != nil {
 			log.Fatalf("[retrieveCookie] error reading cookies from Chrome database: %v", err)
 			return nil, err
@@ -226,57 +661,183 @@ func retrieveCookie(cookie string, readcookie bool, cookieDbFile string) (map[st
 	return nil, errors.New("[retrieveCookie] either --readcookie or --cookie=<cookie> needs to be given")
 }
 
-func pullAWS(awsPuller AWSPuller, reportfile *os.File, group string, account AccountEntry, csvData [][]string, month string, costType string) ([][]string, float64, error) {
+func pullAWS(awsPuller AWSPuller, reportfile *os.File, calibrator *Calibrator, detector *AnomalyDetector, drillDown bool, group string, account AccountEntry, csvData []ReportRow, dateRange DateRange, costType string) ([]ReportRow, float64, error) {
 	log.Printf("[pullAWS] pulling AWS data for account %s", account.AccountID)
-	result, err := awsPuller.PullData(account.AccountID, month, costType)
-	if err != nil {
-		log.Fatalf("[pullAWS] error pulling data from AWS for account %s: %v", account.AccountID, err)
-		return csvData, 0, err
-	}	
-	total, err := awsPuller.CheckResponseConsistency(account, result)
+	results, err := awsPuller.PullData(account.AccountID, dateRange, costType)
 	if err != nil {
-		log.Printf("[pullAWS] consistency check failed on response for account data %s: %v", account.AccountID, err)
-		writeReport(reportfile, account.AccountID + ": " + err.Error())
-	} else {
-		log.Printf("[pullAWS] successful consistency check for data on account %s\n", account.AccountID)
+		log.Printf("[pullAWS] error pulling data from AWS for account %s: %v", account.AccountID, err)
+		return csvData, 0, &StageError{Stage: StageAWSPull, Err: err}
 	}
-	normalized, err := awsPuller.NormalizeResponse(group, month, account.AccountID, result)
-	if err != nil {
-		log.Fatalf("[pullAWS] error normalizing data from AWS for account %s: %v", account.AccountID, err)
-		return csvData, 0, err
-	}	
-	if csvData != nil {
-		csvData = appendCSVData(csvData, account.AccountID, normalized)	
+	var usageTypes map[string]map[string]map[string]float64
+	if drillDown {
+		usageTypes, err = awsPuller.PullUsageTypes(account.AccountID, dateRange, costType)
+		if err != nil {
+			log.Printf("[pullAWS] error pulling usage types from AWS for account %s: %v", account.AccountID, err)
+			return csvData, 0, &StageError{Stage: StageAWSPull, Err: err}
+		}
+	}
+	var total float64 = 0
+	for _, result := range results {
+		periodTotal, err := awsPuller.CheckResponseConsistency(calibrateAccount(calibrator, account, result.Period), result.Costs())
+		if err != nil {
+			log.Printf("[pullAWS] consistency check failed on response for account data %s (%s): %v", account.AccountID, result.Period, err)
+			writeReport(reportfile, account.AccountID + " (" + result.Period.String() + "): " + err.Error())
+		} else {
+			log.Printf("[pullAWS] successful consistency check for data on account %s (%s)\n", account.AccountID, result.Period)
+		}
+		total += periodTotal
+		detectAnomalies(detector, reportfile, group, account.AccountID, result.Period, result.Services)
+		normalized, unmapped, err := awsPuller.NormalizeResponse(group, result.Period.String(), account.AccountID, result.Services, result.RecordTypes)
+		if err != nil {
+			log.Printf("[pullAWS] error normalizing data from AWS for account %s: %v", account.AccountID, err)
+			return csvData, 0, &StageError{Stage: StageAWSNormalize, Period: result.Period.String(), Err: err}
+		}
+		normalized.Conversion = result.Conversion
+		if usageTypes != nil {
+			columns := awsPuller.ReclassifyUsageTypes(normalized, usageTypes[result.Period.String()])
+			if len(columns) > 0 {
+				log.Printf("[pullAWS] reclassified usage types for account %s (%s): %s", account.AccountID, result.Period, formatColumnChanges(columns))
+				writeReport(reportfile, account.AccountID + " (" + result.Period.String() + "): reclassified usage types: " + formatColumnChanges(columns))
+			}
+		}
+		missing := normalized.SetAccountMetadata(account)
+		if len(missing) > 0 && csvData != nil {
+			log.Printf("[pullAWS] warning: account %s (%s) is missing metadata: %s", account.AccountID, result.Period, strings.Join(missing, ", "))
+			writeReport(reportfile, account.AccountID + " (" + result.Period.String() + "): missing account metadata, left PENDING: " + strings.Join(missing, ", "))
+		}
+		if len(unmapped) > 0 {
+			log.Printf("[pullAWS] warning: unmapped services for account %s (%s) added to other: %s", account.AccountID, result.Period, formatServices(unmapped))
+			writeReport(reportfile, account.AccountID + " (" + result.Period.String() + "): unmapped services added to other: " + formatServices(unmapped))
+		}
+		if csvData != nil {
+			csvData = appendCSVData(csvData, account.AccountID, normalized)
+		}
 	}
 	return csvData, total, nil
 }
 
-func pullCostManagement(cmPuller CMPuller, reportfile *os.File, account AccountEntry, csvData [][]string, month string) ([][]string, float64, error) {
-	log.Printf("[pullCostManagement] pulling cost management data for account %s", account.AccountID)
-	result, err := cmPuller.PullData(account.AccountID)
+// pullAccountResults stores the result of pull for an account in the results, for modes with
+// their own output. The returned rows have no cost values, one per month of the date range,
+// they order the output of the results by group and account.
+func pullAccountResults(results *AccountResults, group string, account AccountEntry, dateRange DateRange, what string, pull func() (interface{}, error)) ([]ReportRow, error) {
+	log.Printf("[pullAccountResults] pulling AWS %s for account %s", what, account.AccountID)
+	result, err := pull()
+	if err != nil {
+		log.Printf("[pullAccountResults] error pulling %s from AWS for account %s: %v", what, account.AccountID, err)
+		return nil, &StageError{Stage: StageAWSPull, Err: err}
+	}
+	results.Add(account.AccountID, result)
+	rows := []ReportRow{}
+	for _, period := range dateRange.Months() {
+		rows = append(rows, *NewReportRow(group, period.String(), account.AccountID))
+	}
+	return rows, nil
+}
+
+func pullPurchase(awsPuller AWSPuller, reportfile *os.File, calibrator *Calibrator, detector *AnomalyDetector, results *AccountResults, group string, account AccountEntry, dateRange DateRange, costType string) ([]ReportRow, error) {
+	// the machines column is split without drill down, which moves cost out of it
+	rows, _, err := pullAWS(awsPuller, reportfile, calibrator, detector, false, group, account, []ReportRow{}, dateRange, costType)
 	if err != nil {
-		log.Fatalf("[pullCostManagement] error pulling data from service: %v", err)
-		return csvData, 0, err
+		return rows, err
 	}
-	parsed, err := cmPuller.ParseResponse(result)
+	log.Printf("[pullPurchase] pulling AWS purchase types for account %s", account.AccountID)
+	purchases, err := awsPuller.PullPurchaseTypes(account.AccountID, dateRange, costType, awsPuller.MachineServices(rows))
 	if err != nil {
-		log.Fatalf("[pullCostManagement] error parsing data from service: %v", err)
-		return csvData, 0, err
+		log.Printf("[pullPurchase] error pulling purchase types from AWS for account %s: %v", account.AccountID, err)
+		return nil, &StageError{Stage: StageAWSPull, Err: err}
 	}
-	total, err := cmPuller.CheckResponseConsistency(account, parsed)
+	results.Add(account.AccountID, purchases)
+	return rows, nil
+}
+
+func pullForecast(awsPuller AWSPuller, reportfile *os.File, calibrator *Calibrator, results *AccountResults, group string, account AccountEntry, month DateRange, today time.Time, costType string, predictionInterval int64) ([]ReportRow, error) {
+	log.Printf("[pullForecast] pulling AWS forecast for account %s", account.AccountID)
+	forecast, err := awsPuller.PullForecast(account.AccountID, month, today, costType, predictionInterval)
 	if err != nil {
-		log.Printf("[pullCostManagement] error checking consistency of response for account data %s: %v", account.AccountID, err)
-		writeReport(reportfile, account.AccountID + " (CM): " + err.Error())
-	} else {
-		log.Printf("[pullCostManagement] successful consistency check for data on account %s\n", account.AccountID)
+		log.Printf("[pullForecast] error pulling forecast from AWS for account %s: %v", account.AccountID, err)
+		return nil, &StageError{Stage: StageAWSForecast, Period: month.String(), Err: err}
+	}
+	results.Add(account.AccountID, AccountForecast{Account: account, Forecast: forecast})
+	overrun := ForecastOverrun(calibrateAccount(calibrator, account, month), forecast)
+	if overrun != "" {
+		log.Printf("[pullForecast] warning: account %s (%s): %s", account.AccountID, month, overrun)
+		writeReport(reportfile, account.AccountID + " (" + month.String() + "): " + overrun)
+	}
+	projected := awsPuller.ProjectedRow(group, account.AccountID, forecast)
+	missing := projected.SetAccountMetadata(account)
+	if len(missing) > 0 {
+		log.Printf("[pullForecast] warning: account %s (%s) is missing metadata: %s", account.AccountID, month, strings.Join(missing, ", "))
+		writeReport(reportfile, account.AccountID + " (" + month.String() + "): missing account metadata, left PENDING: " + strings.Join(missing, ", "))
+	}
+	return appendCSVData([]ReportRow{}, account.AccountID, projected), nil
+}
+
+func pullDaily(awsPuller AWSPuller, reportfile *os.File, calibrator *Calibrator, results *AccountResults, group string, account AccountEntry, month DateRange, until time.Time, costType string) ([]ReportRow, error) {
+	log.Printf("[pullDaily] pulling daily AWS data for account %s", account.AccountID)
+	daily, err := awsPuller.PullDaily(account.AccountID, month, until, costType)
+	if err != nil {
+		log.Printf("[pullDaily] error pulling daily data from AWS for account %s: %v", account.AccountID, err)
+		return nil, &StageError{Stage: StageAWSPull, Period: month.String(), Err: err}
+	}
+	results.Add(account.AccountID, daily)
+	checked := calibrateAccount(calibrator, account, month)
+	if checked.Standardvalue > 0 && daily.Projection() > checked.Standardvalue * (1 + float64(checked.Deviationpercent) / 100) {
+		log.Printf("[pullDaily] warning: account %s (%s) is projected to %.2f, standard value is %.2f", account.AccountID, month, daily.Projection(), checked.Standardvalue)
+		writeReport(reportfile, fmt.Sprintf("%s (%s): budget overrun projected: burn rate %.2f per day (%.2f over the last %d days), projection is %.2f, standard value %.2f with max deviation %d%%", account.AccountID, month, daily.BurnRate(), daily.RecentBurnRate(RecentBurnRateDays), RecentBurnRateDays, daily.Projection(), checked.Standardvalue, checked.Deviationpercent))
 	}
-	normalized, err := cmPuller.NormalizeResponse(parsed)
+	normalized, _, err := awsPuller.NormalizeResponse(group, month.String(), account.AccountID, daily.Services, daily.RecordTypes)
 	if err != nil {
-		log.Fatalf("[pullCostManagement] error normalizing data from service: %v", err)
-		return csvData, 0, err
+		log.Printf("[pullDaily] error normalizing data from AWS for account %s: %v", account.AccountID, err)
+		return nil, &StageError{Stage: StageAWSNormalize, Period: month.String(), Err: err}
 	}
-	if csvData != nil {
-		csvData = appendCSVData(csvData, account.AccountID, normalized)
+	normalized.Conversion = daily.Conversion
+	return appendCSVData([]ReportRow{}, account.AccountID, normalized), nil
+}
+
+func pullCostManagement(cmPuller CMPuller, reportfile *os.File, calibrator *Calibrator, group string, account AccountEntry, csvData []ReportRow, dateRange DateRange) ([]ReportRow, float64, error) {
+	log.Printf("[pullCostManagement] pulling cost management data for account %s", account.AccountID)
+	var total float64 = 0
+	for _, period := range dateRange.Months() {
+		result, err := cmPuller.PullData(account.AccountID, period)
+		if err != nil {
+			log.Printf("[pullCostManagement] error pulling data from service: %v", err)
+			return csvData, 0, &StageError{Stage: StageCMPull, Period: period.String(), Err: err}
+		}
+		parsed, err := cmPuller.ParseResponse(result)
+		if err != nil {
+			log.Printf("[pullCostManagement] error parsing data from service: %v", err)
+			return csvData, 0, &StageError{Stage: StageCMParse, Period: period.String(), Err: err}
+		}
+		err = cmPuller.CheckResponsePeriod(period, parsed)
+		if err != nil {
+			log.Printf("[pullCostManagement] error checking period of response for account %s (%s): %v", account.AccountID, period, err)
+			return csvData, 0, &StageError{Stage: StageCMPeriod, Period: period.String(), Err: err}
+		}
+		periodTotal, err := cmPuller.CheckResponseConsistency(calibrateAccount(calibrator, account, period), parsed)
+		if err != nil {
+			log.Printf("[pullCostManagement] error checking consistency of response for account data %s (%s): %v", account.AccountID, period, err)
+			writeReport(reportfile, account.AccountID + " (CM, " + period.String() + "): " + err.Error())
+		} else {
+			log.Printf("[pullCostManagement] successful consistency check for data on account %s (%s)\n", account.AccountID, period)
+		}
+		total += periodTotal
+		normalized, unmapped, err := cmPuller.NormalizeResponse(group, period.String(), parsed)
+		if err != nil {
+			log.Printf("[pullCostManagement] error normalizing data from service: %v", err)
+			return csvData, 0, &StageError{Stage: StageCMNormalize, Period: period.String(), Err: err}
+		}
+		missing := normalized.SetAccountMetadata(account)
+		if len(missing) > 0 && csvData != nil {
+			log.Printf("[pullCostManagement] warning: account %s (%s) is missing metadata: %s", account.AccountID, period, strings.Join(missing, ", "))
+			writeReport(reportfile, account.AccountID + " (CM, " + period.String() + "): missing account metadata, left PENDING: " + strings.Join(missing, ", "))
+		}
+		if len(unmapped) > 0 {
+			log.Printf("[pullCostManagement] warning: unmapped services for account %s (%s) added to other: %s", account.AccountID, period, formatServices(unmapped))
+			writeReport(reportfile, account.AccountID + " (CM, " + period.String() + "): unmapped services added to other: " + formatServices(unmapped))
+		}
+		if csvData != nil {
+			csvData = appendCSVData(csvData, account.AccountID, normalized)
+		}
 	}
 	return csvData, total, nil
 }
@@ -302,32 +863,17 @@ func deserializeChromeCookie(chromeCookies []*kooky.Cookie) (map[string]string,
 	return deserialized, nil
 }
 
-func appendCSVHeader(csvData [][]string, group string) [][]string {
-	log.Printf("[appendcsvheader] appended header for group %s\n", group)
-	header := make([]string, 1)
-	header[0] = group
-	return append(csvData, header)
-}
-
-func appendCSVData(csvData [][]string, account string, data []string) [][]string {
+func appendCSVData(csvData []ReportRow, account string, data *ReportRow) []ReportRow {
 	log.Printf("[appendcsvdata] appended data for account %s\n", account)
-	return append(csvData, data)
+	return append(csvData, *data)
 }
 
-func writeCSV(outfile *os.File, data [][]string) error {
-	writer := csv.NewWriter(outfile)
-	defer writer.Flush()
-	for _, value := range data {
-		err := writer.Write(value)
-		if err != nil {
-			log.Printf("[writecsv] error writing csv data to file: %v ", err)
-			return err
-		}
-	}
-	return nil
-}
+// reportMutex serializes report writes of parallel account pulls.
+var reportMutex sync.Mutex
 
 func writeReport(outfile *os.File, data string) error {
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
 	_, err := outfile.WriteString(data + "\n")
 	if err != nil {
 		log.Printf("[writereport] error writing report data to file: %v ", err)
@@ -350,16 +896,16 @@ func getAccountSetsFromFile(accountsFile string) (map[string][]AccountEntry, err
 	}
 	// set category manually on all entries
 	for category, accountEntries := range accounts {
-		for _, accountEntry := range accountEntries {
-			accountEntry.Category = category
+		for idx := range accountEntries {
+			accountEntries[idx].Category = category
 		}
 	}
 	return accounts, nil
 }
 
-func getAccountSetsFromAWS(awsPuller *AWSPuller) (map[string][]AccountEntry, error) {
+func getAccountSetsFromAWS(awsPuller *AWSPuller, concurrency int) (map[string][]AccountEntry, error) {
 	log.Println("[main] initiating account metadata pull")
-	metadata, err := awsPuller.GetAWSAccountMetadata()
+	metadata, err := awsPuller.GetAWSAccountMetadata(concurrency)
 	if err != nil {
 		log.Fatalf("[main] error getting accounts list from metadata: %v", err)
 	}
@@ -380,6 +926,12 @@ func getAccountSetsFromAWS(awsPuller *AWSPuller) (map[string][]AccountEntry, err
 					Deviationpercent: 0,
 					Category:         category,
 					Description:      description,
+					PO:               accountMetadata[AWSTagCostpullerPO],
+					ClusterID:        accountMetadata[AWSTagCostpullerClusterID],
+					ClusterType:      accountMetadata[AWSTagCostpullerClusterType],
+					UsageType:        accountMetadata[AWSTagCostpullerUsageType],
+					Product:          accountMetadata[AWSTagCostpullerProduct],
+					NumberUsers:      accountMetadata[AWSTagCostpullerNumberUsers],
 				})	
 			}
 		} else {
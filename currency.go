@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultCurrency is the default reporting currency.
+const DefaultCurrency = "USD"
+
+// ExchangeRates holds exchange rates per month as units of a currency per unit of the base currency.
+type ExchangeRates struct {
+	Base  string                        `yaml:"base"`
+	Rates map[string]map[string]float64 `yaml:"rates"`
+}
+
+// LoadExchangeRates reads an exchange rate file. If no file is given, nil is returned and
+// only amounts already in the reporting currency can be used.
+func LoadExchangeRates(ratesFile string) (*ExchangeRates, error) {
+	if ratesFile == "" {
+		return nil, nil
+	}
+	yamlFile, err := ioutil.ReadFile(ratesFile)
+	if err != nil {
+		log.Printf("[loadexchangerates] error reading exchange rate file: %v ", err)
+		return nil, err
+	}
+	rates := new(ExchangeRates)
+	err = yaml.Unmarshal(yamlFile, rates)
+	if err != nil {
+		log.Printf("[loadexchangerates] error unmarshalling exchange rate file: %v", err)
+		return nil, err
+	}
+	if rates.Base == "" {
+		return nil, fmt.Errorf("exchange rate file %s has no base currency", ratesFile)
+	}
+	for month, monthRates := range rates.Rates {
+		if _, err := parseRangeBoundary(month, false); err != nil || len(month) != len(monthFormat) {
+			return nil, fmt.Errorf("invalid month %s in exchange rate file %s, needs to be in format yyyy-mm", month, ratesFile)
+		}
+		for currency, rate := range monthRates {
+			if rate <= 0 {
+				return nil, fmt.Errorf("invalid exchange rate %f for %s in %s", rate, currency, month)
+			}
+		}
+	}
+	log.Printf("[loadexchangerates] loaded exchange rates for %d months from %s", len(rates.Rates), ratesFile)
+	return rates, nil
+}
+
+// rate returns the units of the currency per unit of the base currency for a month.
+func (e *ExchangeRates) rate(currency string, month string) (float64, error) {
+	if currency == e.Base {
+		return 1, nil
+	}
+	rate, ok := e.Rates[month][currency]
+	if !ok {
+		return 0, fmt.Errorf("no exchange rate for %s in %s", currency, month)
+	}
+	return rate, nil
+}
+
+// Rate returns the factor converting amounts from one currency into another for a month.
+func (e *ExchangeRates) Rate(from string, to string, month string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	if e == nil {
+		return 0, fmt.Errorf("amounts in %s can not be converted to %s without an exchange rate file", from, to)
+	}
+	fromRate, err := e.rate(from, month)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := e.rate(to, month)
+	if err != nil {
+		return 0, err
+	}
+	return toRate / fromRate, nil
+}
+
+// Conversion describes how amounts were converted from their original currency into the
+// reporting currency.
+type Conversion struct {
+	Currency         string
+	OriginalCurrency string
+	Rate             float64
+}
+
+// Original returns an amount in the reporting currency in the original currency.
+func (c Conversion) Original(amount float64) float64 {
+	if c.Rate == 0 {
+		return amount
+	}
+	return amount / c.Rate
+}
+
+// CurrencyConverter converts amounts into the reporting currency.
+type CurrencyConverter struct {
+	rates    *ExchangeRates
+	currency string
+}
+
+// NewCurrencyConverter returns a new converter into the reporting currency.
+func NewCurrencyConverter(rates *ExchangeRates, currency string) *CurrencyConverter {
+	converter := new(CurrencyConverter)
+	converter.rates = rates
+	converter.currency = currency
+	return converter
+}
+
+// Conversion returns the conversion of amounts in the given currency for a month. Amounts
+// without currency, eg. of periods without cost, are in the reporting currency. A nil
+// converter keeps all amounts in their currency.
+func (c *CurrencyConverter) Conversion(currency string, month string) (Conversion, error) {
+	if c == nil {
+		if currency == "" {
+			currency = DefaultCurrency
+		}
+		return Conversion{Currency: currency, OriginalCurrency: currency, Rate: 1}, nil
+	}
+	if currency == "" {
+		currency = c.currency
+	}
+	rate, err := c.rates.Rate(currency, c.currency, month)
+	if err != nil {
+		return Conversion{}, err
+	}
+	return Conversion{Currency: c.currency, OriginalCurrency: currency, Rate: rate}, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExchangeRates(t *testing.T) {
+	rates, err := LoadExchangeRates("rates.yaml.example")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cases := []struct {
+		from  string
+		to    string
+		month string
+		rate  float64
+	}{
+		{"USD", "USD", "2025-12", 1},
+		{"EUR", "USD", "2026-01", 1 / 0.92},
+		{"USD", "EUR", "2026-02", 0.93},
+		{"GBP", "EUR", "2026-01", 0.92 / 0.79},
+	}
+	for _, c := range cases {
+		rate, err := rates.Rate(c.from, c.to, c.month)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if math.Abs(rate-c.rate) > 1e-9 {
+			t.Errorf("expected rate %f from %s to %s in %s, got %f", c.rate, c.from, c.to, c.month, rate)
+		}
+	}
+	if _, err := rates.Rate("EUR", "USD", "2025-12"); err == nil {
+		t.Errorf("expected error for month without rates")
+	}
+	var none *ExchangeRates
+	if _, err := none.Rate("EUR", "USD", "2026-01"); err == nil {
+		t.Errorf("expected error converting without exchange rates")
+	}
+	// amounts without currency, eg. of periods without cost, need no exchange rate
+	conversion, err := NewCurrencyConverter(nil, "EUR").Conversion("", "2026-01")
+	if err != nil || conversion != (Conversion{Currency: "EUR", OriginalCurrency: "EUR", Rate: 1}) {
+		t.Errorf("expected amounts without currency to be in the reporting currency, got %v (%v)", conversion, err)
+	}
+}
+
+func TestParseResponseConverts(t *testing.T) {
+	rates := &ExchangeRates{Base: "USD", Rates: map[string]map[string]float64{"2026-01": {"EUR": 0.5}}}
+	puller := NewCMPuller(false, nil, DefaultCMBaseURL, nil, nil, DefaultServiceMapping(), nil, NewCurrencyConverter(rates, "EUR"))
+	response, err := puller.ParseResponse([]byte(`{
+		"meta": {"filter": {"account": ["111111111111"]}, "total": {"cost": {"total": {"value": 10, "units": "USD"}}}},
+		"data": [{"date": "2026-01", "services": [{"service": "AmazonEC2", "values": [{"date": "2026-01", "cost": {"total": {"value": 10, "units": "USD"}}}]}]}]
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	row, _, err := puller.NormalizeResponse("someGroup", "2026-01", response)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if row.Machines != 5 || row.Conversion.Currency != "EUR" || row.Conversion.OriginalCurrency != "USD" {
+		t.Errorf("expected 5 EUR converted from USD, got %f %v", row.Machines, row.Conversion)
+	}
+	if original := row.Conversion.Original(row.Total()); original != 10 {
+		t.Errorf("expected original net of 10, got %f", original)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+// RecentBurnRateDays is the number of days the recent burn rate is averaged over.
+const RecentBurnRateDays = 7
+
+// DailyCosts describes the daily costs of an account for the days of a month pulled so far.
+// The daily costs include credits, refunds and tax.
+type DailyCosts struct {
+	Month DateRange
+	// Days holds the cost per day, starting with the first day of the month.
+	Days []float64
+	// Services and RecordTypes are the service and record type breakdown of the month to date cost.
+	Services    map[string]float64
+	RecordTypes map[string]float64
+	Conversion  Conversion
+}
+
+// MonthToDate returns the sum of the daily costs.
+func (d *DailyCosts) MonthToDate() float64 {
+	var total float64 = 0
+	for _, cost := range d.Days {
+		total += cost
+	}
+	return total
+}
+
+// BurnRate returns the average daily cost.
+func (d *DailyCosts) BurnRate() float64 {
+	if len(d.Days) == 0 {
+		return 0
+	}
+	return d.MonthToDate() / float64(len(d.Days))
+}
+
+// RecentBurnRate returns the average daily cost of the last days.
+func (d *DailyCosts) RecentBurnRate(days int) float64 {
+	if days > len(d.Days) {
+		days = len(d.Days)
+	}
+	if days == 0 {
+		return 0
+	}
+	var total float64 = 0
+	for _, cost := range d.Days[len(d.Days)-days:] {
+		total += cost
+	}
+	return total / float64(days)
+}
+
+// Projection returns the linear projection of the cost of the whole month from the burn rate.
+func (d *DailyCosts) Projection() float64 {
+	return d.BurnRate() * d.Month.End.Sub(d.Month.Start).Hours() / 24
+}
+
+// PullDaily retrieves the daily costs of an account from the beginning of the month until
+// the given day (exclusive).
+func (a *AWSPuller) PullDaily(accountID string, month DateRange, until time.Time, costType string) (*DailyCosts, error) {
+	if !until.After(month.Start) || until.After(month.End) {
+		return nil, fmt.Errorf("end of daily pull %s is not within %s", until.Format(dayFormat), month)
+	}
+	results, err := a.pullData(accountID, DateRange{Start: month.Start, End: until}, costType, costexplorer.GranularityDaily)
+	if err != nil {
+		return nil, err
+	}
+	daily := &DailyCosts{
+		Month:       month,
+		Days:        make([]float64, int(until.Sub(month.Start).Hours()/24)),
+		Services:    map[string]float64{},
+		RecordTypes: map[string]float64{},
+	}
+	for _, result := range results {
+		day := int(result.Period.Start.Sub(month.Start).Hours() / 24)
+		if day < 0 || day >= len(daily.Days) {
+			log.Printf("[pullawsdaily] error: account %s result for %s is not within the pulled days", accountID, result.Period)
+			return nil, fmt.Errorf("[pullawsdaily] error: account %s result for %s is not within the pulled days", accountID, result.Period)
+		}
+		daily.Conversion = result.Conversion
+		for _, value := range result.Costs() {
+			daily.Days[day] += value
+		}
+		for service, value := range result.Services {
+			daily.Services[service] += value
+		}
+		for recordType, value := range result.RecordTypes {
+			daily.RecordTypes[recordType] += value
+		}
+	}
+	return daily, nil
+}
+
+// writeDailyCSV writes a daily table per category: a row with the sum of the category,
+// followed by a row per account with the cost per day, the month to date cost, the burn rate
+// and the projection for the month. The results hold the daily costs of the accounts.
+func writeDailyCSV(outfile *os.File, month DateRange, days int, rows []ReportRow, results *AccountResults) error {
+	writer := csv.NewWriter(outfile)
+	defer writer.Flush()
+	header := []string{"group", "accountId"}
+	for day := 0; day < days; day++ {
+		header = append(header, month.Start.AddDate(0, 0, day).Format(dayFormat))
+	}
+	header = append(header, "monthToDate", "burnRate", "recentBurnRate", "projection")
+	err := writer.Write(header)
+	if err != nil {
+		log.Printf("[writedailycsv] error writing csv header to file: %v ", err)
+		return err
+	}
+	record := func(group string, accountID string, costs *DailyCosts) []string {
+		line := []string{group, accountID}
+		for _, cost := range costs.Days {
+			line = append(line, fmt.Sprintf("%f", cost))
+		}
+		return append(line,
+			fmt.Sprintf("%f", costs.MonthToDate()),
+			fmt.Sprintf("%f", costs.BurnRate()),
+			fmt.Sprintf("%f", costs.RecentBurnRate(RecentBurnRateDays)),
+			fmt.Sprintf("%f", costs.Projection()),
+		)
+	}
+	for _, groupRows := range categoryRows(rows) {
+		category := &DailyCosts{Month: month, Days: make([]float64, days)}
+		accounts := [][]string{}
+		for _, row := range groupRows {
+			costs, ok := results.Get(row.AccountID).(*DailyCosts)
+			if row.Failed != "" || !ok {
+				accounts = append(accounts, failedLine(len(header), row.Group, row.AccountID))
+				continue
+			}
+			for day, cost := range costs.Days {
+				category.Days[day] += cost
+			}
+			accounts = append(accounts, record(row.Group, row.AccountID, costs))
+		}
+		err := writer.WriteAll(append([][]string{record(groupRows[0].Group, "ALL", category)}, accounts...))
+		if err != nil {
+			log.Printf("[writedailycsv] error writing csv data to file: %v ", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPullDaily(t *testing.T) {
+	puller, _, _ := newFixturePuller(t, "costexplorer_daily.json")
+	month := mustParseDateRange(t, "2026-10", "2026-10")
+	daily, err := puller.PullDaily("111111111111", month, time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC), "UnblendedCost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(daily.Days, []float64{12, 10, 42}) {
+		t.Errorf("unexpected daily costs: %v", daily.Days)
+	}
+	if daily.MonthToDate() != 64 || daily.Services["Amazon Simple Storage Service"] != 4 {
+		t.Errorf("unexpected month to date cost: %f, %v", daily.MonthToDate(), daily.Services)
+	}
+	if daily.BurnRate() != 64.0/3 || daily.RecentBurnRate(2) != 26 || daily.RecentBurnRate(RecentBurnRateDays) != daily.BurnRate() {
+		t.Errorf("unexpected burn rates: %f, %f", daily.BurnRate(), daily.RecentBurnRate(2))
+	}
+	if projection := daily.Projection(); projection < 661.33 || projection > 661.34 {
+		t.Errorf("expected projection of 661.33 for 31 days, got %f", projection)
+	}
+	if _, err := puller.PullDaily("111111111111", month, month.Start, "UnblendedCost"); err == nil {
+		t.Error("expected error for pull without days")
+	}
+}
+
+func TestWriteDailyCSV(t *testing.T) {
+	month := mustParseDateRange(t, "2026-10", "2026-10")
+	results := NewAccountResults()
+	results.Add("111111111111", &DailyCosts{Month: month, Days: []float64{1, 3}})
+	results.Add("222222222222", &DailyCosts{Month: month, Days: []float64{2, 2}})
+	// failed accounts are written in place, the table has no date column
+	failed := NewReportRow("a", "2026-10", "333333333333")
+	failed.Failed = StageAWSPull
+	rows := []ReportRow{
+		*NewReportRow("a", "2026-10", "111111111111"),
+		*NewReportRow("a", "2026-10", "222222222222"),
+		*failed,
+	}
+	records := readCSVOutput(t, func(outfile *os.File) error {
+		return writeDailyCSV(outfile, month, 2, rows, results)
+	})
+	expected := [][]string{
+		{"group", "accountId", "2026-10-01", "2026-10-02", "monthToDate", "burnRate", "recentBurnRate", "projection"},
+		{"a", "ALL", "3.000000", "5.000000", "8.000000", "4.000000", "4.000000", "124.000000"},
+		{"a", "111111111111", "1.000000", "3.000000", "4.000000", "2.000000", "2.000000", "62.000000"},
+		{"a", "222222222222", "2.000000", "2.000000", "4.000000", "2.000000", "2.000000", "62.000000"},
+		{"a", "333333333333", FailedValue, FailedValue, FailedValue, FailedValue, FailedValue, FailedValue},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("unexpected daily table:\n%v\nexpected:\n%v", records, expected)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/now"
+)
+
+const monthFormat = "2006-01"
+const dayFormat = "2006-01-02"
+
+// DateRange describes a period of days, Start is inclusive, End is exclusive.
+type DateRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// ParseDateRange creates a DateRange from the month, from and to arguments. Either month
+// or from (optionally with to) needs to be given. Values are in format yyyy-mm for whole
+// months or yyyy-mm-dd for single days, to is inclusive.
+func ParseDateRange(month string, from string, to string) (DateRange, error) {
+	if month != "" {
+		if from != "" || to != "" {
+			return DateRange{}, errors.New("month can not be combined with from and to")
+		}
+		from = month
+	}
+	if from == "" {
+		return DateRange{}, errors.New("no month or date range given")
+	}
+	if to == "" {
+		to = from
+	}
+	start, err := parseRangeBoundary(from, false)
+	if err != nil {
+		return DateRange{}, err
+	}
+	end, err := parseRangeBoundary(to, true)
+	if err != nil {
+		return DateRange{}, err
+	}
+	if !end.After(start) {
+		return DateRange{}, fmt.Errorf("end of date range %s is before start %s", to, from)
+	}
+	return DateRange{Start: start, End: end}, nil
+}
+
+// parseRangeBoundary parses a month or day value. If end is set, the returned
+// time is the exclusive end of the given month or day.
+func parseRangeBoundary(value string, end bool) (time.Time, error) {
+	if day, err := time.Parse(dayFormat, value); err == nil {
+		if end {
+			return day.AddDate(0, 0, 1), nil
+		}
+		return day, nil
+	}
+	month, err := time.Parse(monthFormat, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date %s is neither in format yyyy-mm nor yyyy-mm-dd", value)
+	}
+	if end {
+		return now.With(month).BeginningOfMonth().AddDate(0, 1, 0), nil
+	}
+	return now.With(month).BeginningOfMonth(), nil
+}
+
+// StartDay returns the start day in format yyyy-mm-dd.
+func (d DateRange) StartDay() string {
+	return d.Start.Format(dayFormat)
+}
+
+// EndDay returns the exclusive end day in format yyyy-mm-dd.
+func (d DateRange) EndDay() string {
+	return d.End.Format(dayFormat)
+}
+
+// IsMonth returns true if the range covers exactly one calendar month.
+func (d DateRange) IsMonth() bool {
+	beginningOfMonth := now.With(d.Start).BeginningOfMonth()
+	return d.Start.Equal(beginningOfMonth) && d.End.Equal(beginningOfMonth.AddDate(0, 1, 0))
+}
+
+// Months splits the range into periods that do not cross month boundaries.
+func (d DateRange) Months() []DateRange {
+	months := []DateRange{}
+	start := d.Start
+	for start.Before(d.End) {
+		end := now.With(start).BeginningOfMonth().AddDate(0, 1, 0)
+		if end.After(d.End) {
+			end = d.End
+		}
+		months = append(months, DateRange{Start: start, End: end})
+		start = end
+	}
+	return months
+}
+
+// String returns yyyy-mm for whole months, otherwise the inclusive day range.
+func (d DateRange) String() string {
+	if d.IsMonth() {
+		return d.Start.Format(monthFormat)
+	}
+	return fmt.Sprintf("%s/%s", d.StartDay(), d.End.AddDate(0, 0, -1).Format(dayFormat))
+}
+
+// ParsePeriod parses a period in the format returned by String.
+func ParsePeriod(period string) (DateRange, error) {
+	if days := strings.Split(period, "/"); len(days) == 2 {
+		return ParseDateRange("", days[0], days[1])
+	}
+	return ParseDateRange(period, "", "")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDateRange(t *testing.T) {
+	cases := []struct {
+		month   string
+		from    string
+		to      string
+		period  string
+		isMonth bool
+		months  []string
+	}{
+		{"2026-02", "", "", "2026-02", true, []string{"2026-02"}},
+		{"", "2026-02", "", "2026-02", true, []string{"2026-02"}},
+		{"", "2026-02-01", "2026-02-28", "2026-02", true, []string{"2026-02"}},
+		{"", "2026-03-01", "", "2026-03-01/2026-03-01", false, []string{"2026-03-01/2026-03-01"}},
+		{"", "2026-01", "2026-03", "2026-01-01/2026-03-31", false, []string{"2026-01", "2026-02", "2026-03"}},
+		{"", "2026-01-15", "2026-02-10", "2026-01-15/2026-02-10", false, []string{"2026-01-15/2026-01-31", "2026-02-01/2026-02-10"}},
+		{"", "2026-01-20", "2026-02", "2026-01-20/2026-02-28", false, []string{"2026-01-20/2026-01-31", "2026-02"}},
+		{"", "2026-01", "2026-02-05", "2026-01-01/2026-02-05", false, []string{"2026-01", "2026-02-01/2026-02-05"}},
+		{"", "2025-12-15", "2026-01-15", "2025-12-15/2026-01-15", false, []string{"2025-12-15/2025-12-31", "2026-01-01/2026-01-15"}},
+		{"", "2025-11", "2026-02", "2025-11-01/2026-02-28", false, []string{"2025-11", "2025-12", "2026-01", "2026-02"}},
+	}
+	for _, c := range cases {
+		dateRange, err := ParseDateRange(c.month, c.from, c.to)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q %q %q: %v", c.month, c.from, c.to, err)
+			continue
+		}
+		if period := dateRange.String(); period != c.period {
+			t.Errorf("expected %q %q %q to be period %s, got %s", c.month, c.from, c.to, c.period, period)
+		}
+		if isMonth := dateRange.IsMonth(); isMonth != c.isMonth {
+			t.Errorf("expected %q %q %q to be a month: %t, got %t", c.month, c.from, c.to, c.isMonth, isMonth)
+		}
+		months := []string{}
+		for _, month := range dateRange.Months() {
+			months = append(months, month.String())
+		}
+		if !reflect.DeepEqual(months, c.months) {
+			t.Errorf("expected %q %q %q to split into %v, got %v", c.month, c.from, c.to, c.months, months)
+		}
+		parsed, err := ParsePeriod(dateRange.String())
+		if err != nil {
+			t.Errorf("unexpected error parsing period %s: %v", dateRange.String(), err)
+		} else if !parsed.Start.Equal(dateRange.Start) || !parsed.End.Equal(dateRange.End) {
+			t.Errorf("expected period %s to parse into %v, got %v", dateRange.String(), dateRange, parsed)
+		}
+	}
+}
+
+func TestParseDateRangeInvalid(t *testing.T) {
+	cases := []struct {
+		month string
+		from  string
+		to    string
+	}{
+		{"", "", ""},
+		{"", "", "2026-02"},
+		{"2026-02", "2026-01", ""},
+		{"2026-02", "", "2026-03"},
+		{"2026-02", "2026-01", "2026-03"},
+		{"", "2026-03", "2026-02"},
+		{"", "2026-02-10", "2026-02-09"},
+		{"", "2026-01", "2025-12-31"},
+		{"", "2026/01", ""},
+		{"", "2026-01", "2026-13"},
+	}
+	for _, c := range cases {
+		if _, err := ParseDateRange(c.month, c.from, c.to); err == nil {
+			t.Errorf("expected error parsing %q %q %q", c.month, c.from, c.to)
+		}
+	}
+	if _, err := ParsePeriod("2026-02-10/2026-02-09"); err == nil {
+		t.Error("expected error parsing period with end before start")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+// PullUsageTypes retrieves the cost of the services with usage type entries in the mapping
+// grouped by usage type, by period, service and usage type. As for the service costs, credits,
+// refunds and tax are excluded. Amounts are converted into the reporting currency.
+func (a *AWSPuller) PullUsageTypes(accountID string, dateRange DateRange, costType string) (map[string]map[string]map[string]float64, error) {
+	usageTypes := make(map[string]map[string]map[string]float64)
+	services := a.mapping.DrillDownServices()
+	if len(services) == 0 {
+		return usageTypes, nil
+	}
+	dimensionServiceKey := "SERVICE"
+	serviceValues := []*string{}
+	for idx := range services {
+		serviceValues = append(serviceValues, &services[idx])
+	}
+	filter := withoutSeparatedRecordTypes(&costexplorer.Expression{
+		Dimensions: &costexplorer.DimensionValues{
+			Key:    &dimensionServiceKey,
+			Values: serviceValues,
+		},
+	})
+	results, err := a.PullGrouped(accountID, dateRange, costType, dimensionGroups("SERVICE", "USAGE_TYPE"), filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		period := make(map[string]map[string]float64)
+		for _, group := range result.Groups {
+			if len(group.Keys) != 2 {
+				log.Printf("[pullawsusagetypes] error: account %s usage type group does not have exactly two keys", accountID)
+				return nil, fmt.Errorf("[pullawsusagetypes] error: account %s usage type group does not have exactly two keys", accountID)
+			}
+			conversion, err := a.groupConversion(result.Period, group)
+			if err != nil {
+				log.Printf("[pullawsusagetypes] error converting account %s usage type cost: %v", accountID, err)
+				return nil, err
+			}
+			if period[group.Keys[0]] == nil {
+				period[group.Keys[0]] = make(map[string]float64)
+			}
+			period[group.Keys[0]][group.Keys[1]] += group.Amount * conversion.Rate
+		}
+		usageTypes[result.Period.String()] = period
+	}
+	return usageTypes, nil
+}
+
+// ReclassifyUsageTypes moves the cost of the usage types of a period from the columns of their
+// services to the columns of their usage types. Returns the column changes.
+func (a *AWSPuller) ReclassifyUsageTypes(row *ReportRow, usageTypes map[string]map[string]float64) map[string]float64 {
+	columns := a.mapping.Reclassify(usageTypes)
+	row.AddColumns(columns)
+	return columns
+}
+
+// formatColumnChanges formats the column changes of a reclassification in the order of the
+// report columns.
+func formatColumnChanges(columns map[string]float64) string {
+	formatted := []string{}
+	for _, column := range mappingColumns {
+		if value, ok := columns[column]; ok {
+			formatted = append(formatted, fmt.Sprintf("%s (%+.2f)", column, value))
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
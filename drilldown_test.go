@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDrillDown(t *testing.T) {
+	puller, _, _ := newFixturePuller(t, "costexplorer_drilldown.json")
+	err := puller.mapping.compile()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dateRange := mustParseDateRange(t, "2026-01", "")
+	results, err := puller.PullData("111111111111", dateRange, "UnblendedCost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	usageTypes, err := puller.PullUsageTypes("111111111111", dateRange, "UnblendedCost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(usageTypes["2026-01"]["EC2 - Other"]) != 4 || usageTypes["2026-01"]["Amazon Simple Storage Service"]["USE1-DataTransfer-Out-Bytes"] != 2 {
+		t.Errorf("unexpected usage types: %v", usageTypes)
+	}
+	row, _, err := puller.NormalizeResponse("someGroup", "2026-01", "111111111111", results[0].Services, results[0].RecordTypes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	total := row.Total()
+	columns := puller.ReclassifyUsageTypes(row, usageTypes["2026-01"])
+	if columns[ColumnMachines] != -17 || columns[ColumnStorage] != 10 || columns[ColumnDataTransfer] != 7 {
+		t.Errorf("unexpected column changes: %v", columns)
+	}
+	if row.Machines != 103 || row.Storage != 20 || row.DataTransfer != 12 {
+		t.Errorf("expected machines 103, storage 20 and data transfer 12, got %f, %f and %f", row.Machines, row.Storage, row.DataTransfer)
+	}
+	if row.Total() != total {
+		t.Errorf("expected total %f to be kept, got %f", total, row.Total())
+	}
+	if formatted := formatColumnChanges(columns); formatted != "dataTransfer (+7.00), machines (-17.00), storage (+10.00)" {
+		t.Errorf("unexpected formatted changes: %s", formatted)
+	}
+}
+
+func TestUsageTypeMappingValidation(t *testing.T) {
+	for _, entry := range []UsageTypeEntry{
+		{Service: "EC2 - Other", Match: "EBS:", Column: "disks"},
+		{Match: "EBS:", Column: ColumnStorage},
+		{Service: "EC2 - Other", Match: "(", Column: ColumnStorage},
+	} {
+		mapping := &ServiceMapping{UsageTypes: []UsageTypeEntry{entry}}
+		if err := mapping.compile(); err == nil {
+			t.Errorf("expected error for usage type entry %+v", entry)
+		}
+	}
+}
+
+func TestDrillDownIgnoresHistory(t *testing.T) {
+	history, cleanup := openTestHistory(t)
+	defer cleanup()
+	run := HistoryRun{ID: "run", Time: time.Now(), Mode: "aws", CostType: "UnblendedCost"}
+	if err := history.PutRun(run, SourceAWS, []ReportRow{historyTestRow("a", "2026-01", "111111111111", 120)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reportfile, err := ioutil.TempFile("", "report")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(reportfile.Name())
+	defer reportfile.Close()
+	puller, _, _ := newFixturePuller(t, "costexplorer_drilldown.json")
+	if err := puller.mapping.compile(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	account := AccountEntry{AccountID: "111111111111"}
+	dateRange := mustParseDateRange(t, "2026-01", "")
+	for _, c := range []struct {
+		drillDown   bool
+		fromHistory bool
+		machines    float64
+	}{
+		{false, true, 120},
+		{true, false, 103},
+	} {
+		rows, err := pullAWSOrStored(*puller, reportfile, nil, nil, c.drillDown, history, true, "a", account, dateRange, "UnblendedCost")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(rows) != 1 || rows[0].FromHistory != c.fromHistory || rows[0].Machines != c.machines {
+			t.Errorf("expected drill down %t to use history %t with machines %f, got %v", c.drillDown, c.fromHistory, c.machines, rows)
+		}
+	}
+}
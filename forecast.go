@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+// DefaultPredictionInterval is the default confidence level of forecast intervals in percent.
+const DefaultPredictionInterval = 80
+
+// forecastMetrics maps cost types to Cost Explorer forecast metrics. Usage metrics are not
+// forecasted.
+var forecastMetrics = map[string]string{
+	"AmortizedCost":    costexplorer.MetricAmortizedCost,
+	"BlendedCost":      costexplorer.MetricBlendedCost,
+	"NetAmortizedCost": costexplorer.MetricNetAmortizedCost,
+	"NetUnblendedCost": costexplorer.MetricNetUnblendedCost,
+	"UnblendedCost":    costexplorer.MetricUnblendedCost,
+}
+
+// AWSForecast describes the projected cost of an account for a month. The month to date
+// cost is pulled, the cost of the remaining days is forecasted.
+type AWSForecast struct {
+	Month DateRange
+	// MonthToDate is the cost from the beginning of the month until the forecast start.
+	MonthToDate float64
+	// Services and RecordTypes are the service and record type breakdown of the month to date cost.
+	Services    map[string]float64
+	RecordTypes map[string]float64
+	Conversion  Conversion
+	// Forecast, Lower and Upper are the projected cost of the whole month and the bounds of
+	// the prediction interval.
+	Forecast float64
+	Lower    float64
+	Upper    float64
+}
+
+// PullForecast retrieves the month to date cost of an account and forecasts the cost of the
+// remaining days of the month starting today. The forecast bounds are given with the
+// predictionInterval confidence level in percent.
+func (a *AWSPuller) PullForecast(accountID string, month DateRange, today time.Time, costType string, predictionInterval int64) (*AWSForecast, error) {
+	metric, ok := forecastMetrics[costType]
+	if !ok {
+		return nil, fmt.Errorf("cost type %s can not be forecasted", costType)
+	}
+	if today.Before(month.Start) || !today.Before(month.End) {
+		return nil, fmt.Errorf("forecast start %s is not within %s", today.Format(dayFormat), month)
+	}
+	forecast := &AWSForecast{
+		Month:       month,
+		Services:    map[string]float64{},
+		RecordTypes: map[string]float64{},
+	}
+	if today.After(month.Start) {
+		results, err := a.PullData(accountID, DateRange{Start: month.Start, End: today}, costType)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range results {
+			for _, value := range result.Costs() {
+				forecast.MonthToDate += value
+			}
+			for service, value := range result.Services {
+				forecast.Services[service] += value
+			}
+			for recordType, value := range result.RecordTypes {
+				forecast.RecordTypes[recordType] += value
+			}
+		}
+	}
+	dayStart := today.Format(dayFormat)
+	dayEnd := month.EndDay()
+	log.Printf("[pullawsforecast] forecasting %s to %s for account %s", dayStart, dayEnd, accountID)
+	granularity := "MONTHLY"
+	dimensionLinkedAccountKey := "LINKED_ACCOUNT"
+	var output *costexplorer.GetCostForecastOutput
+	err := a.callCostExplorer("GetCostForecast", func() (err error) {
+		output, err = a.costExplorer.GetCostForecast(&costexplorer.GetCostForecastInput{
+			TimePeriod: &costexplorer.DateInterval{
+				Start: &dayStart,
+				End:   &dayEnd,
+			},
+			Granularity: &granularity,
+			Metric:      &metric,
+			Filter: &costexplorer.Expression{
+				Dimensions: &costexplorer.DimensionValues{
+					Key:    &dimensionLinkedAccountKey,
+					Values: []*string{&accountID},
+				},
+			},
+			PredictionIntervalLevel: &predictionInterval,
+		})
+		return err
+	})
+	if err != nil {
+		log.Printf("[pullawsforecast] error retrieving aws cost forecast: %v\n", err)
+		return nil, err
+	}
+	if a.debug {
+		log.Println("[pullawsforecast] received forecast:")
+		log.Println(*output)
+	}
+	unit := ""
+	if output.Total != nil && output.Total.Unit != nil {
+		unit = *output.Total.Unit
+	}
+	forecast.Conversion, err = a.converter.Conversion(unit, month.Start.Format(monthFormat))
+	if err != nil {
+		log.Printf("[pullawsforecast] error converting forecast for account %s: %v", accountID, err)
+		return nil, err
+	}
+	forecast.Forecast = forecast.MonthToDate
+	forecast.Lower = forecast.MonthToDate
+	forecast.Upper = forecast.MonthToDate
+	for _, result := range output.ForecastResultsByTime {
+		for _, value := range []struct {
+			amount *string
+			sum    *float64
+		}{
+			{result.MeanValue, &forecast.Forecast},
+			{result.PredictionIntervalLowerBound, &forecast.Lower},
+			{result.PredictionIntervalUpperBound, &forecast.Upper},
+		} {
+			if value.amount == nil {
+				continue
+			}
+			amount, err := strconv.ParseFloat(*value.amount, 64)
+			if err != nil {
+				log.Printf("[pullawsforecast] error converting aws forecast value: %v", err)
+				return nil, err
+			}
+			*value.sum += amount * forecast.Conversion.Rate
+		}
+	}
+	return forecast, nil
+}
+
+// ProjectedRow returns the normalized month to date cost of the forecast, with all columns
+// scaled to the forecasted cost of the month. Without month to date cost, the forecast is
+// added to the other column.
+func (a *AWSPuller) ProjectedRow(group string, accountID string, forecast *AWSForecast) *ReportRow {
+	row := NewReportRow(group, forecast.Month.String(), accountID)
+	row.Services = forecast.Services
+	row.Conversion = forecast.Conversion
+	if forecast.MonthToDate == 0 {
+		row.Other = forecast.Forecast
+		return row
+	}
+	factor := forecast.Forecast / forecast.MonthToDate
+	columns, _ := a.mapping.Normalize(SourceAWS, forecast.Services)
+	for column, value := range columns {
+		columns[column] = value * factor
+	}
+	row.AddColumns(columns)
+	recordTypes := make(map[string]float64)
+	for recordType, value := range forecast.RecordTypes {
+		recordTypes[recordType] = value * factor
+	}
+	row.AddRecordTypes(recordTypes)
+	return row
+}
+
+// ForecastOverrun returns a warning if the forecast exceeds the standard value of the account
+// by more than the allowed deviation, or an empty string.
+func ForecastOverrun(account AccountEntry, forecast *AWSForecast) string {
+	if account.Standardvalue <= 0 {
+		return ""
+	}
+	limit := account.Standardvalue * (1 + float64(account.Deviationpercent)/100)
+	if forecast.Forecast <= limit {
+		return ""
+	}
+	likelihood := "expected"
+	if forecast.Lower > limit {
+		likelihood = "expected even at the lower bound"
+	}
+	return fmt.Sprintf("budget overrun %s: forecast is %.2f (%.2f to %.2f), standard value %.2f with max deviation %d%%", likelihood, forecast.Forecast, forecast.Lower, forecast.Upper, account.Standardvalue, account.Deviationpercent)
+}
+
+// forecastHeader are the columns appended to the report layout in forecast mode.
+var forecastHeader = []string{"monthToDate", "forecast", "forecastLower", "forecastUpper", "standardValue"}
+
+// AccountForecast is the forecast of an account with the account it was pulled for.
+type AccountForecast struct {
+	Account  AccountEntry
+	Forecast *AWSForecast
+}
+
+// writeForecastCSV writes the projected rows in the report layout, followed by the month to
+// date cost, the forecast with its interval and the standard value of the account. The results
+// hold the forecasts of the accounts.
+func writeForecastCSV(outfile *os.File, rows []ReportRow, results *AccountResults) error {
+	writer := csv.NewWriter(outfile)
+	defer writer.Flush()
+	err := writer.Write(append(append([]string{}, reportHeader...), forecastHeader...))
+	if err != nil {
+		log.Printf("[writeforecastcsv] error writing csv header to file: %v ", err)
+		return err
+	}
+	for _, row := range rows {
+		record := row.Record()
+		forecast, ok := results.Get(row.AccountID).(AccountForecast)
+		if row.Failed != "" || !ok {
+			record = failedLine(len(reportHeader)+len(forecastHeader), record...)
+		} else {
+			record = append(record,
+				fmt.Sprintf("%f", forecast.Forecast.MonthToDate),
+				fmt.Sprintf("%f", forecast.Forecast.Forecast),
+				fmt.Sprintf("%f", forecast.Forecast.Lower),
+				fmt.Sprintf("%f", forecast.Forecast.Upper),
+				fmt.Sprintf("%f", forecast.Account.Standardvalue),
+			)
+		}
+		err := writer.Write(record)
+		if err != nil {
+			log.Printf("[writeforecastcsv] error writing csv data to file: %v ", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPullForecast(t *testing.T) {
+	puller, _, _ := newFixturePuller(t, "costexplorer_forecast.json")
+	month := mustParseDateRange(t, "2026-10", "2026-10")
+	today := time.Date(2026, 10, 11, 0, 0, 0, 0, time.UTC)
+	forecast, err := puller.PullForecast("111111111111", month, today, "UnblendedCost", DefaultPredictionInterval)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if forecast.MonthToDate != 400 || forecast.Forecast != 1200 || forecast.Lower != 1100 || forecast.Upper != 1350 {
+		t.Errorf("unexpected forecast: %+v", forecast)
+	}
+	row := puller.ProjectedRow("a", "111111111111", forecast)
+	if row.Date != "2026-10" || row.Machines != 900 || row.Storage != 300 || row.Total() != 1200 {
+		t.Errorf("unexpected projected row: %+v", row)
+	}
+	if _, err := puller.PullForecast("111111111111", month, today, "Unknown", DefaultPredictionInterval); err == nil {
+		t.Error("expected error for cost type without forecast metric")
+	}
+	if _, err := puller.PullForecast("111111111111", month, month.End, "UnblendedCost", DefaultPredictionInterval); err == nil {
+		t.Error("expected error for forecast start outside of the month")
+	}
+}
+
+func TestForecastOverrun(t *testing.T) {
+	forecast := &AWSForecast{Forecast: 1200, Lower: 1100, Upper: 1350}
+	if got := ForecastOverrun(AccountEntry{Standardvalue: 0}, forecast); got != "" {
+		t.Errorf("expected no warning without standard value, got %s", got)
+	}
+	if got := ForecastOverrun(AccountEntry{Standardvalue: 1000, Deviationpercent: 20}, forecast); got != "" {
+		t.Errorf("expected no warning within deviation, got %s", got)
+	}
+	if got := ForecastOverrun(AccountEntry{Standardvalue: 1000, Deviationpercent: 15}, forecast); !strings.HasPrefix(got, "budget overrun expected:") {
+		t.Errorf("expected overrun warning, got %s", got)
+	}
+	if got := ForecastOverrun(AccountEntry{Standardvalue: 1000, Deviationpercent: 5}, forecast); !strings.HasPrefix(got, "budget overrun expected even at the lower bound") {
+		t.Errorf("expected overrun warning at the lower bound, got %s", got)
+	}
+}
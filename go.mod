@@ -7,5 +7,6 @@ require (
 	github.com/go-delve/delve v1.4.1 // indirect
 	github.com/jinzhu/now v1.1.1
 	github.com/zellyn/kooky v0.0.0-20200206144811-607d4ccbb896
+	go.etcd.io/bbolt v1.3.5
 	gopkg.in/yaml.v2 v2.2.8
 )
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var historyRecordsBucket = []byte("records")
+var historyRunsBucket = []byte("runs")
+
+// HistoryRun describes the metadata of a run that stored records.
+type HistoryRun struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Mode     string    `json:"mode"`
+	CostType string    `json:"costType"`
+	Range    string    `json:"range"`
+	Rows     int       `json:"rows"`
+	Failures int       `json:"failures"`
+}
+
+// HistoryRecord describes a stored normalized row with the service breakdown it was normalized from.
+type HistoryRecord struct {
+	RunID     string             `json:"runId"`
+	RunTime   time.Time          `json:"runTime"`
+	Source    string             `json:"source"`
+	CostType  string             `json:"costType"`
+	Category  string             `json:"category"`
+	AccountID string             `json:"accountId"`
+	Period    string             `json:"period"`
+	Currency  string             `json:"currency,omitempty"`
+	Total     float64            `json:"total"`
+	Row       ReportRow          `json:"row"`
+	Services  map[string]float64 `json:"services"`
+}
+
+// HistoryFilter selects stored records. Empty fields match all records, periods are
+// compared as strings, so yyyy-mm bounds select whole months.
+type HistoryFilter struct {
+	Source    string
+	CostType  string
+	Category  string
+	AccountID string
+	From      string
+	To        string
+}
+
+// HistoryStore persists pulled data in an embedded database. Records are keyed by source,
+// cost type, reporting currency, account and period, pulling a period again replaces the
+// stored record. A store only reads and writes the records of its reporting currency.
+type HistoryStore struct {
+	db       *bolt.DB
+	currency string
+}
+
+// OpenHistoryStore opens or creates the history database file for the given reporting currency.
+func OpenHistoryStore(file string, currency string) (*HistoryStore, error) {
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		log.Printf("[openhistorystore] error opening history database: %v", err)
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{historyRecordsBucket, historyRunsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		log.Printf("[openhistorystore] error initializing history database: %v", err)
+		return nil, err
+	}
+	return &HistoryStore{db: db, currency: currency}, nil
+}
+
+// Close closes the database.
+func (h *HistoryStore) Close() error {
+	return h.db.Close()
+}
+
+// key returns the key of a record in the reporting currency of the store. Records in the
+// default currency keep the key of databases written before currencies were supported.
+func (h *HistoryStore) key(source string, costType string, accountID string, period string) []byte {
+	parts := []string{source, costType, accountID, period}
+	if h.currency != DefaultCurrency {
+		parts = append(parts, h.currency)
+	}
+	return []byte(strings.Join(parts, "|"))
+}
+
+// recordCurrency returns the reporting currency of a record, records written before
+// currencies were supported are in the default currency.
+func recordCurrency(record HistoryRecord) string {
+	if record.Currency == "" {
+		return DefaultCurrency
+	}
+	return record.Currency
+}
+
+// isCompletePeriod returns true if the period ended before the given time. Periods that
+// are not parseable are considered complete.
+func isCompletePeriod(period string, at time.Time) bool {
+	dateRange, err := ParsePeriod(period)
+	if err != nil {
+		return true
+	}
+	return !dateRange.End.After(at)
+}
+
+// PutRun stores the run metadata together with the rows of the run. Failed rows and rows read
+// from the history are not stored, neither are rows of periods that are not complete at the
+// time of the run, eg. the current month, as their values are not final.
+func (h *HistoryStore) PutRun(run HistoryRun, source string, rows []ReportRow) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		records := tx.Bucket(historyRecordsBucket)
+		for _, row := range rows {
+			if row.Failed != "" || row.FromHistory {
+				continue
+			}
+			if !isCompletePeriod(row.Date, run.Time) {
+				log.Printf("[puthistoryrun] not storing incomplete period %s of account %s", row.Date, row.AccountID)
+				continue
+			}
+			record := HistoryRecord{
+				RunID:     run.ID,
+				RunTime:   run.Time,
+				Source:    source,
+				CostType:  run.CostType,
+				Category:  row.Group,
+				AccountID: row.AccountID,
+				Period:    row.Date,
+				Currency:  h.currency,
+				Total:     row.Total(),
+				Row:       row,
+				Services:  row.Services,
+			}
+			value, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			err = records.Put(h.key(source, run.CostType, row.AccountID, row.Date), value)
+			if err != nil {
+				return err
+			}
+		}
+		value, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(historyRunsBucket).Put([]byte(run.ID), value)
+	})
+}
+
+// Get returns the stored record for an account and period, or nil if there is none.
+func (h *HistoryStore) Get(source string, costType string, accountID string, period string) (*HistoryRecord, error) {
+	var record *HistoryRecord
+	err := h.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(historyRecordsBucket).Get(h.key(source, costType, accountID, period))
+		if value == nil {
+			return nil
+		}
+		record = new(HistoryRecord)
+		return json.Unmarshal(value, record)
+	})
+	return record, err
+}
+
+// PreviousMonths returns the stored records of an account for up to months months before the
+// month of the given time, latest month first. Months that are not stored are skipped.
+func (h *HistoryStore) PreviousMonths(source string, costType string, accountID string, before time.Time, months int) ([]HistoryRecord, error) {
+	records := []HistoryRecord{}
+	beginningOfMonth := time.Date(before.Year(), before.Month(), 1, 0, 0, 0, 0, before.Location())
+	for i := 1; i <= months; i++ {
+		month := beginningOfMonth.AddDate(0, -i, 0).Format(monthFormat)
+		record, err := h.Get(source, costType, accountID, month)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			records = append(records, *record)
+		}
+	}
+	return records, nil
+}
+
+// Query returns the records in the reporting currency of the store matching the filter,
+// sorted by source, cost type, account and period.
+func (h *HistoryStore) Query(filter HistoryFilter) ([]HistoryRecord, error) {
+	records := []HistoryRecord{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(historyRecordsBucket).ForEach(func(key []byte, value []byte) error {
+			record := HistoryRecord{}
+			err := json.Unmarshal(value, &record)
+			if err != nil {
+				return err
+			}
+			if recordCurrency(record) == h.currency && filter.matches(record) {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (f HistoryFilter) matches(record HistoryRecord) bool {
+	if f.Source != "" && f.Source != record.Source {
+		return false
+	}
+	if f.CostType != "" && f.CostType != record.CostType {
+		return false
+	}
+	if f.Category != "" && f.Category != record.Category {
+		return false
+	}
+	if f.AccountID != "" && f.AccountID != record.AccountID {
+		return false
+	}
+	if f.From != "" && record.Period < f.From {
+		return false
+	}
+	if f.To != "" && record.Period > f.To {
+		return false
+	}
+	return true
+}
+
+// Runs returns all stored runs.
+func (h *HistoryStore) Runs() ([]HistoryRun, error) {
+	runs := []HistoryRun{}
+	err := h.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(historyRunsBucket).ForEach(func(key []byte, value []byte) error {
+			run := HistoryRun{}
+			err := json.Unmarshal(value, &run)
+			if err != nil {
+				return err
+			}
+			runs = append(runs, run)
+			return nil
+		})
+	})
+	return runs, err
+}
+
+// writeHistoryCSV writes the records in the report layout with leading source, cost type and run columns.
+func writeHistoryCSV(outfile *os.File, records []HistoryRecord) error {
+	writer := csv.NewWriter(outfile)
+	defer writer.Flush()
+	header := append([]string{"source", "costType", "runId", "runTime"}, reportHeader...)
+	err := writer.Write(header)
+	if err != nil {
+		log.Printf("[writehistorycsv] error writing csv header to file: %v ", err)
+		return err
+	}
+	for _, record := range records {
+		line := append([]string{record.Source, record.CostType, record.RunID, record.RunTime.Format(time.RFC3339)}, record.Row.Record()...)
+		err := writer.Write(line)
+		if err != nil {
+			log.Printf("[writehistorycsv] error writing csv data to file: %v ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// historyPeriodBound converts a month or day flag value into a period bound for filtering.
+func historyPeriodBound(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if _, err := parseRangeBoundary(value, false); err != nil {
+		return "", fmt.Errorf("invalid history period bound: %v", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestHistory(t *testing.T) (*HistoryStore, func()) {
+	t.Helper()
+	return openTestHistoryCurrency(t, DefaultCurrency)
+}
+
+func openTestHistoryCurrency(t *testing.T, currency string) (*HistoryStore, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "costpuller")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	history, err := OpenHistoryStore(filepath.Join(dir, "history.db"), currency)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return history, func() {
+		history.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func historyTestRow(category string, period string, accountID string, machines float64) ReportRow {
+	row := NewReportRow(category, period, accountID)
+	row.Machines = machines
+	row.Services = map[string]float64{"Amazon Elastic Compute Cloud - Compute": machines}
+	return *row
+}
+
+func TestHistoryStore(t *testing.T) {
+	history, cleanup := openTestHistory(t)
+	defer cleanup()
+	failed := NewReportRow("a", "2026-02", "222222222222")
+	failed.Failed = StageAWSPull
+	rows := []ReportRow{
+		historyTestRow("a", "2026-01", "111111111111", 100),
+		historyTestRow("a", "2026-02", "111111111111", 110),
+		historyTestRow("b", "2026-02", "333333333333", 50),
+		*failed,
+	}
+	run := HistoryRun{ID: "run1", Time: time.Now(), Mode: "aws", CostType: "UnblendedCost"}
+	if err := history.PutRun(run, SourceAWS, rows); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// a second run replaces the stored month
+	run.ID = "run2"
+	if err := history.PutRun(run, SourceAWS, []ReportRow{historyTestRow("a", "2026-02", "111111111111", 120)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	record, err := history.Get(SourceAWS, "UnblendedCost", "111111111111", "2026-02")
+	if err != nil || record == nil {
+		t.Fatalf("expected stored record, got %v (%v)", record, err)
+	}
+	if record.RunID != "run2" || record.Total != 120 || record.Services["Amazon Elastic Compute Cloud - Compute"] != 120 {
+		t.Errorf("unexpected record: %v", record)
+	}
+	if record, _ := history.Get(SourceAWS, "UnblendedCost", "222222222222", "2026-02"); record != nil {
+		t.Errorf("expected failed row not to be stored, got %v", record)
+	}
+	records, err := history.Query(HistoryFilter{Source: SourceAWS, Category: "a", From: "2026-02"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(records) != 1 || records[0].AccountID != "111111111111" {
+		t.Errorf("unexpected query result: %v", records)
+	}
+	runs, err := history.Runs()
+	if err != nil || len(runs) != 2 {
+		t.Errorf("expected two stored runs, got %v (%v)", runs, err)
+	}
+}
+
+func TestHistoryStoreSkipsIncompletePeriods(t *testing.T) {
+	history, cleanup := openTestHistory(t)
+	defer cleanup()
+	rows := []ReportRow{
+		historyTestRow("a", "2026-01", "111111111111", 100),
+		historyTestRow("a", "2026-02", "111111111111", 50),
+		historyTestRow("a", "2026-02-01/2026-02-10", "111111111111", 20),
+	}
+	run := HistoryRun{ID: "run", Time: time.Date(2026, 2, 15, 12, 0, 0, 0, time.UTC), Mode: "aws", CostType: "UnblendedCost"}
+	if err := history.PutRun(run, SourceAWS, rows); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, c := range []struct {
+		period string
+		stored bool
+	}{
+		{"2026-01", true},
+		{"2026-02", false},
+		{"2026-02-01/2026-02-10", true},
+	} {
+		record, err := history.Get(SourceAWS, "UnblendedCost", "111111111111", c.period)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if (record != nil) != c.stored {
+			t.Errorf("expected period %s stored to be %t, got %v", c.period, c.stored, record)
+		}
+	}
+}
+
+func TestHistoryStoreSkipsStoredRows(t *testing.T) {
+	history, cleanup := openTestHistory(t)
+	defer cleanup()
+	run := HistoryRun{ID: "run1", Time: time.Now(), Mode: "aws", CostType: "UnblendedCost"}
+	if err := history.PutRun(run, SourceAWS, []ReportRow{historyTestRow("a", "2026-01", "111111111111", 100)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rows, err := storedRows(history, SourceAWS, "UnblendedCost", AccountEntry{AccountID: "111111111111"}, mustParseDateRange(t, "2026-01", ""))
+	if err != nil || len(rows) != 1 || !rows[0].FromHistory {
+		t.Fatalf("expected stored row, got %v (%v)", rows, err)
+	}
+	run = HistoryRun{ID: "run2", Time: time.Now(), Mode: "change", CostType: "UnblendedCost"}
+	if err := history.PutRun(run, SourceAWS, append(rows, historyTestRow("a", "2026-02", "111111111111", 110))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, c := range []struct {
+		period string
+		runID  string
+	}{
+		{"2026-01", "run1"},
+		{"2026-02", "run2"},
+	} {
+		record, err := history.Get(SourceAWS, "UnblendedCost", "111111111111", c.period)
+		if err != nil || record == nil || record.RunID != c.runID {
+			t.Errorf("expected %s to be stored by %s, got %v (%v)", c.period, c.runID, record, err)
+		}
+	}
+}
+
+func TestHistoryStoreCurrencies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "costpuller")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history.db")
+	run := HistoryRun{ID: "run", Time: time.Now(), Mode: "aws", CostType: "UnblendedCost"}
+	for _, c := range []struct {
+		currency string
+		machines float64
+	}{
+		{"USD", 100},
+		{"EUR", 90},
+	} {
+		history, err := OpenHistoryStore(file, c.currency)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if record, _ := history.Get(SourceAWS, "UnblendedCost", "111111111111", "2026-01"); record != nil {
+			t.Errorf("expected no %s record before storing, got %v", c.currency, record)
+		}
+		err = history.PutRun(run, SourceAWS, []ReportRow{historyTestRow("a", "2026-01", "111111111111", c.machines)})
+		history.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for _, c := range []struct {
+		currency string
+		total    float64
+	}{
+		{"USD", 100},
+		{"EUR", 90},
+	} {
+		history, err := OpenHistoryStore(file, c.currency)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		records, err := history.Query(HistoryFilter{})
+		history.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(records) != 1 || records[0].Total != c.total || records[0].Currency != c.currency {
+			t.Errorf("expected one %s record with total %f, got %v", c.currency, c.total, records)
+		}
+	}
+}
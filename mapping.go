@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Report columns services can be mapped to.
+const (
+	ColumnDataTransfer = "dataTransfer"
+	ColumnMachines     = "machines"
+	ColumnStorage      = "storage"
+	ColumnKeyMgmnt     = "keyMgmnt"
+	ColumnRegistrar    = "registrar"
+	ColumnDNS          = "dns"
+	ColumnOther        = "other"
+	ColumnTax          = "tax"
+	ColumnRefund       = "refund"
+	ColumnCredit       = "credit"
+)
+
+// Report columns summing up the cost columns.
+const (
+	ColumnGross = "gross"
+	ColumnNet   = "net"
+)
+
+// Data sources with separate sections in the service mapping.
+const (
+	SourceAWS = "aws"
+	SourceCM  = "cm"
+)
+
+var mappingColumns = []string{
+	ColumnDataTransfer,
+	ColumnMachines,
+	ColumnStorage,
+	ColumnKeyMgmnt,
+	ColumnRegistrar,
+	ColumnDNS,
+	ColumnOther,
+	ColumnTax,
+	ColumnRefund,
+	ColumnCredit,
+}
+
+// ServiceMapping maps service names to report columns, with one section per data source. The
+// usage types of AWS services can be reclassified into other columns with a drill down.
+type ServiceMapping struct {
+	AWS        []MappingEntry   `yaml:"aws"`
+	CM         []MappingEntry   `yaml:"cm"`
+	UsageTypes []UsageTypeEntry `yaml:"usagetypes"`
+}
+
+// MappingEntry maps a service name or a regular expression on service names to a column.
+type MappingEntry struct {
+	Service string `yaml:"service,omitempty"`
+	Match   string `yaml:"match,omitempty"`
+	Column  string `yaml:"column"`
+	matcher *regexp.Regexp
+}
+
+// UsageTypeEntry reclassifies the usage types of an AWS service matching a regular expression
+// into a column.
+type UsageTypeEntry struct {
+	Service string `yaml:"service"`
+	Match   string `yaml:"match"`
+	Column  string `yaml:"column"`
+	matcher *regexp.Regexp
+}
+
+// DefaultServiceMapping returns the built-in service mapping.
+func DefaultServiceMapping() *ServiceMapping {
+	return &ServiceMapping{
+		AWS: []MappingEntry{
+			MappingEntry{Service: "AWS Data Transfer", Column: ColumnDataTransfer},
+			MappingEntry{Service: "Amazon Elastic Compute Cloud - Compute", Column: ColumnMachines},
+			MappingEntry{Service: "EC2 - Other", Column: ColumnMachines},
+			MappingEntry{Service: "Amazon Simple Storage Service", Column: ColumnStorage},
+			MappingEntry{Service: "AWS Key Management Service", Column: ColumnKeyMgmnt},
+			MappingEntry{Service: "AWS Secrets Manager", Column: ColumnKeyMgmnt},
+			MappingEntry{Service: "Amazon Route 53", Column: ColumnDNS},
+		},
+		CM: []MappingEntry{
+			MappingEntry{Service: "AWSDataTransfer", Column: ColumnDataTransfer},
+			MappingEntry{Service: "AmazonEC2", Column: ColumnMachines},
+			MappingEntry{Service: "AmazonS3", Column: ColumnStorage},
+			MappingEntry{Service: "awskms", Column: ColumnKeyMgmnt},
+			MappingEntry{Service: "AmazonRoute53", Column: ColumnDNS},
+		},
+		UsageTypes: []UsageTypeEntry{
+			UsageTypeEntry{Service: "EC2 - Other", Match: "EBS:", Column: ColumnStorage},
+			UsageTypeEntry{Service: "EC2 - Other", Match: "NatGateway-", Column: ColumnDataTransfer},
+			UsageTypeEntry{Service: "EC2 - Other", Match: "DataTransfer-|-AWS-(In|Out)-Bytes", Column: ColumnDataTransfer},
+			UsageTypeEntry{Service: "Amazon Simple Storage Service", Match: "DataTransfer-|-AWS-(In|Out)-Bytes", Column: ColumnDataTransfer},
+		},
+	}
+}
+
+// LoadServiceMapping reads a service mapping file. If no file is given, the built-in mapping is used.
+func LoadServiceMapping(mappingFile string) (*ServiceMapping, error) {
+	if mappingFile == "" {
+		log.Println("[loadservicemapping] no mapping file given, using built-in service mapping")
+		mapping := DefaultServiceMapping()
+		return mapping, mapping.compile()
+	}
+	yamlFile, err := ioutil.ReadFile(mappingFile)
+	if err != nil {
+		log.Printf("[loadservicemapping] error reading mapping file: %v ", err)
+		return nil, err
+	}
+	mapping := new(ServiceMapping)
+	err = yaml.Unmarshal(yamlFile, mapping)
+	if err != nil {
+		log.Printf("[loadservicemapping] error unmarshalling mapping file: %v", err)
+		return nil, err
+	}
+	err = mapping.compile()
+	if err != nil {
+		log.Printf("[loadservicemapping] error in mapping file: %v", err)
+		return nil, err
+	}
+	log.Printf("[loadservicemapping] loaded %d aws and %d cm service mappings and %d usage type mappings from %s", len(mapping.AWS), len(mapping.CM), len(mapping.UsageTypes), mappingFile)
+	return mapping, nil
+}
+
+// compile validates the entries and compiles the regular expressions.
+func (m *ServiceMapping) compile() error {
+	for _, entries := range [][]MappingEntry{m.AWS, m.CM} {
+		for idx := range entries {
+			entry := &entries[idx]
+			if !isMappingColumn(entry.Column) {
+				return fmt.Errorf("unknown column %s in mapping entry %d, needs to be one of %s", entry.Column, idx, strings.Join(mappingColumns, ", "))
+			}
+			if (entry.Service == "") == (entry.Match == "") {
+				return fmt.Errorf("mapping entry %d needs exactly one of service or match", idx)
+			}
+			if entry.Match != "" {
+				matcher, err := regexp.Compile(entry.Match)
+				if err != nil {
+					return fmt.Errorf("invalid match expression in mapping entry %d: %v", idx, err)
+				}
+				entry.matcher = matcher
+			}
+		}
+	}
+	for idx := range m.UsageTypes {
+		entry := &m.UsageTypes[idx]
+		if !isMappingColumn(entry.Column) {
+			return fmt.Errorf("unknown column %s in usage type entry %d, needs to be one of %s", entry.Column, idx, strings.Join(mappingColumns, ", "))
+		}
+		if entry.Service == "" || entry.Match == "" {
+			return fmt.Errorf("usage type entry %d needs service and match", idx)
+		}
+		matcher, err := regexp.Compile(entry.Match)
+		if err != nil {
+			return fmt.Errorf("invalid match expression in usage type entry %d: %v", idx, err)
+		}
+		entry.matcher = matcher
+	}
+	return nil
+}
+
+func isMappingColumn(column string) bool {
+	for _, c := range mappingColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// Column returns the column for a service of the given source. Exact service names take
+// precedence over match expressions, which are evaluated in order.
+func (m *ServiceMapping) Column(source string, service string) (string, bool) {
+	entries := m.AWS
+	if source == SourceCM {
+		entries = m.CM
+	}
+	for _, entry := range entries {
+		if entry.Service == service {
+			return entry.Column, true
+		}
+	}
+	for _, entry := range entries {
+		if entry.matcher != nil && entry.matcher.MatchString(service) {
+			return entry.Column, true
+		}
+	}
+	return "", false
+}
+
+// Normalize sums up the service values per column. Services without a mapping are
+// added to the other column and returned separately.
+func (m *ServiceMapping) Normalize(source string, services map[string]float64) (map[string]float64, map[string]float64) {
+	columns := make(map[string]float64)
+	unmapped := make(map[string]float64)
+	for service, value := range services {
+		column, ok := m.Column(source, service)
+		if !ok {
+			column = ColumnOther
+			unmapped[service] = value
+		}
+		columns[column] += value
+	}
+	return columns, unmapped
+}
+
+// DrillDownServices returns the sorted AWS services with usage type entries.
+func (m *ServiceMapping) DrillDownServices() []string {
+	services := []string{}
+	for _, entry := range m.UsageTypes {
+		found := false
+		for _, service := range services {
+			if service == entry.Service {
+				found = true
+				break
+			}
+		}
+		if !found {
+			services = append(services, entry.Service)
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// UsageTypeColumn returns the column for a usage type of an AWS service. Entries are
+// evaluated in order.
+func (m *ServiceMapping) UsageTypeColumn(service string, usageType string) (string, bool) {
+	for _, entry := range m.UsageTypes {
+		if entry.Service == service && entry.matcher != nil && entry.matcher.MatchString(usageType) {
+			return entry.Column, true
+		}
+	}
+	return "", false
+}
+
+// Reclassify returns the column changes moving the cost of the usage types of AWS services
+// from the column of their service to the column of their usage type. Usage types without an
+// entry stay in the column of their service.
+func (m *ServiceMapping) Reclassify(usageTypes map[string]map[string]float64) map[string]float64 {
+	columns := make(map[string]float64)
+	for service, values := range usageTypes {
+		serviceColumn, ok := m.Column(SourceAWS, service)
+		if !ok {
+			serviceColumn = ColumnOther
+		}
+		for usageType, value := range values {
+			column, ok := m.UsageTypeColumn(service, usageType)
+			if !ok || column == serviceColumn {
+				continue
+			}
+			columns[serviceColumn] -= value
+			columns[column] += value
+		}
+	}
+	return columns
+}
+
+// formatServices formats service values sorted by service name.
+func formatServices(services map[string]float64) string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	formatted := make([]string, 0, len(names))
+	for _, name := range names {
+		formatted = append(formatted, fmt.Sprintf("%s (%.2f)", name, services[name]))
+	}
+	return strings.Join(formatted, ", ")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestServiceMappingColumn(t *testing.T) {
+	mapping := &ServiceMapping{
+		AWS: []MappingEntry{
+			MappingEntry{Match: "^Amazon Elastic", Column: ColumnStorage},
+			MappingEntry{Service: "Amazon Elastic Compute Cloud - Compute", Column: ColumnMachines},
+		},
+		CM: []MappingEntry{
+			MappingEntry{Service: "AmazonEC2", Column: ColumnMachines},
+		},
+	}
+	err := mapping.compile()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cases := []struct {
+		source  string
+		service string
+		column  string
+		mapped  bool
+	}{
+		{SourceAWS, "Amazon Elastic Compute Cloud - Compute", ColumnMachines, true},
+		{SourceAWS, "Amazon Elastic File System", ColumnStorage, true},
+		{SourceAWS, "AmazonEC2", "", false},
+		{SourceCM, "AmazonEC2", ColumnMachines, true},
+	}
+	for _, c := range cases {
+		column, mapped := mapping.Column(c.source, c.service)
+		if column != c.column || mapped != c.mapped {
+			t.Errorf("expected %s service %s to map to %s (%t), got %s (%t)", c.source, c.service, c.column, c.mapped, column, mapped)
+		}
+	}
+}
+
+func TestServiceMappingValidation(t *testing.T) {
+	invalid := []MappingEntry{
+		MappingEntry{Service: "AmazonEC2", Column: "compute"},
+		MappingEntry{Service: "AmazonEC2", Match: "EC2", Column: ColumnMachines},
+		MappingEntry{Match: "(", Column: ColumnMachines},
+	}
+	for _, entry := range invalid {
+		mapping := &ServiceMapping{AWS: []MappingEntry{entry}}
+		if err := mapping.compile(); err == nil {
+			t.Errorf("expected error for entry %v", entry)
+		}
+	}
+}
+
+func TestLoadServiceMappingExample(t *testing.T) {
+	mapping, err := LoadServiceMapping("mapping.yaml.example")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if column, _ := mapping.Column(SourceAWS, "Amazon Elastic Block Store"); column != ColumnStorage {
+		t.Errorf("expected match expression to map to storage, got %s", column)
+	}
+	if column, _ := mapping.UsageTypeColumn("EC2 - Other", "EUC1-NatGateway-Bytes"); column != ColumnDataTransfer {
+		t.Errorf("expected usage type to map to dataTransfer, got %s", column)
+	}
+}
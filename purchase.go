@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+// Purchase options EC2 spend is split into.
+const (
+	PurchaseOnDemand     = "onDemand"
+	PurchaseReserved     = "reserved"
+	PurchaseSavingsPlans = "savingsPlans"
+	PurchaseSpot         = "spot"
+	PurchaseOther        = "otherPurchase"
+)
+
+var purchaseColumns = []string{
+	PurchaseOnDemand,
+	PurchaseReserved,
+	PurchaseSavingsPlans,
+	PurchaseSpot,
+	PurchaseOther,
+}
+
+// purchaseColumn returns the purchase option of a Cost Explorer purchase type, eg. "On Demand
+// Instances" or "Standard Reserved Instances".
+func purchaseColumn(purchaseType string) string {
+	purchaseType = strings.ToLower(purchaseType)
+	switch {
+	case strings.Contains(purchaseType, "spot"):
+		return PurchaseSpot
+	case strings.Contains(purchaseType, "savings plan"):
+		return PurchaseSavingsPlans
+	case strings.Contains(purchaseType, "reserved"):
+		return PurchaseReserved
+	case strings.Contains(purchaseType, "on demand"):
+		return PurchaseOnDemand
+	default:
+		return PurchaseOther
+	}
+}
+
+// committedPercent returns the share of reserved and savings plan covered cost in percent.
+func committedPercent(purchases map[string]float64) float64 {
+	var total float64 = 0
+	for _, column := range purchaseColumns {
+		total += purchases[column]
+	}
+	if total == 0 {
+		return 0
+	}
+	return (purchases[PurchaseReserved] + purchases[PurchaseSavingsPlans]) / total * 100
+}
+
+// MachineServices returns the sorted services of the rows mapped to the machines column.
+func (a *AWSPuller) MachineServices(rows []ReportRow) []string {
+	found := make(map[string]bool)
+	for _, row := range rows {
+		for service := range row.Services {
+			if column, ok := a.mapping.Column(SourceAWS, service); ok && column == ColumnMachines {
+				found[service] = true
+			}
+		}
+	}
+	services := []string{}
+	for service := range found {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// PullPurchaseTypes retrieves the cost of the given services of an account grouped by purchase
+// type, by period and purchase option. As for the service costs, credits, refunds and tax are
+// excluded. Amounts are converted into the reporting currency.
+func (a *AWSPuller) PullPurchaseTypes(accountID string, dateRange DateRange, costType string, services []string) (map[string]map[string]float64, error) {
+	purchases := make(map[string]map[string]float64)
+	if len(services) == 0 {
+		return purchases, nil
+	}
+	dimensionServiceKey := "SERVICE"
+	serviceValues := []*string{}
+	for idx := range services {
+		serviceValues = append(serviceValues, &services[idx])
+	}
+	filter := withoutSeparatedRecordTypes(&costexplorer.Expression{
+		Dimensions: &costexplorer.DimensionValues{
+			Key:    &dimensionServiceKey,
+			Values: serviceValues,
+		},
+	})
+	results, err := a.PullGrouped(accountID, dateRange, costType, dimensionGroups("PURCHASE_TYPE"), filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		period := make(map[string]float64)
+		for _, group := range result.Groups {
+			if len(group.Keys) != 1 {
+				log.Printf("[pullawspurchasetypes] error: account %s purchase type group does not have exactly one key", accountID)
+				return nil, fmt.Errorf("[pullawspurchasetypes] error: account %s purchase type group does not have exactly one key", accountID)
+			}
+			conversion, err := a.groupConversion(result.Period, group)
+			if err != nil {
+				log.Printf("[pullawspurchasetypes] error converting account %s purchase type cost: %v", accountID, err)
+				return nil, err
+			}
+			period[purchaseColumn(group.Keys[0])] += group.Amount * conversion.Rate
+		}
+		purchases[result.Period.String()] = period
+	}
+	return purchases, nil
+}
+
+// writePurchaseCSV writes the machines column and its split into purchase options per category
+// and month: a line with the sum of the category (account id ALL), followed by the lines of the
+// accounts. The committed share is the reserved and savings plan covered cost in percent of the
+// purchase options. The results hold the purchase options of the accounts by period. Failed
+// accounts are written at the end of their category.
+func writePurchaseCSV(outfile *os.File, rows []ReportRow, results *AccountResults) error {
+	writer := csv.NewWriter(outfile)
+	defer writer.Flush()
+	header := append(append([]string{"group", "date", "accountId", ColumnMachines}, purchaseColumns...), "committedPercent")
+	err := writer.Write(header)
+	if err != nil {
+		log.Printf("[writepurchasecsv] error writing csv header to file: %v ", err)
+		return err
+	}
+	record := func(group string, date string, accountID string, machines float64, purchases map[string]float64) []string {
+		line := []string{group, date, accountID, fmt.Sprintf("%f", machines)}
+		for _, column := range purchaseColumns {
+			line = append(line, fmt.Sprintf("%f", purchases[column]))
+		}
+		return append(line, fmt.Sprintf("%.2f", committedPercent(purchases)))
+	}
+	for _, groupRows := range categoryRows(rows) {
+		group := groupRows[0].Group
+		lines := categoryMatrixLines(groupRows, len(header), func(date string, dateRows []ReportRow) [][]string {
+			var machines float64 = 0
+			category := make(map[string]float64)
+			accounts := [][]string{}
+			for _, row := range dateRows {
+				accountPurchases, _ := results.Get(row.AccountID).(map[string]map[string]float64)
+				purchases := accountPurchases[date]
+				accounts = append(accounts, record(group, date, row.AccountID, row.Machines, purchases))
+				machines += row.Machines
+				for column, value := range purchases {
+					category[column] += value
+				}
+			}
+			return append([][]string{record(group, date, "ALL", machines, category)}, accounts...)
+		})
+		err := writer.WriteAll(lines)
+		if err != nil {
+			log.Printf("[writepurchasecsv] error writing csv data to file: %v ", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPullPurchaseTypes(t *testing.T) {
+	puller, _, _ := newFixturePuller(t, "costexplorer_purchase.json")
+	dateRange := mustParseDateRange(t, "2026-01", "")
+	results, err := puller.PullData("111111111111", dateRange, "UnblendedCost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	row, _, err := puller.NormalizeResponse("a", "2026-01", "111111111111", results[0].Services, results[0].RecordTypes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	services := puller.MachineServices([]ReportRow{*row})
+	if !reflect.DeepEqual(services, []string{"Amazon Elastic Compute Cloud - Compute", "EC2 - Other"}) {
+		t.Errorf("unexpected machine services: %v", services)
+	}
+	purchases, err := puller.PullPurchaseTypes("111111111111", dateRange, "UnblendedCost", services)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]map[string]float64{
+		"2026-01": {PurchaseOnDemand: 50, PurchaseReserved: 30, PurchaseSavingsPlans: 25, PurchaseSpot: 15},
+	}
+	if !reflect.DeepEqual(purchases, expected) {
+		t.Errorf("unexpected purchase types: %v", purchases)
+	}
+	if column := purchaseColumn("Dedicated Host"); column != PurchaseOther {
+		t.Errorf("expected unknown purchase type to map to %s, got %s", PurchaseOther, column)
+	}
+	purchaseResults := NewAccountResults()
+	purchaseResults.Add("111111111111", purchases)
+	purchaseResults.Add("222222222222", map[string]map[string]float64{"2026-01": {PurchaseOnDemand: 80}})
+	other := NewReportRow("a", "2026-01", "222222222222")
+	other.Machines = 80
+	records := readCSVOutput(t, func(outfile *os.File) error {
+		return writePurchaseCSV(outfile, []ReportRow{*row, *other}, purchaseResults)
+	})
+	expectedRecords := [][]string{
+		{"group", "date", "accountId", "machines", "onDemand", "reserved", "savingsPlans", "spot", "otherPurchase", "committedPercent"},
+		{"a", "2026-01", "ALL", "200.000000", "130.000000", "30.000000", "25.000000", "15.000000", "0.000000", "27.50"},
+		{"a", "2026-01", "111111111111", "120.000000", "50.000000", "30.000000", "25.000000", "15.000000", "0.000000", "45.83"},
+		{"a", "2026-01", "222222222222", "80.000000", "80.000000", "0.000000", "0.000000", "0.000000", "0.000000", "0.00"},
+	}
+	if !reflect.DeepEqual(records, expectedRecords) {
+		t.Errorf("unexpected purchase csv: %v", records)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Request rates for the AWS APIs. Cost Explorer and Organizations throttle requests
+// per second per account, the rates stay below these limits with some headroom.
+const (
+	CostExplorerRequestsPerSecond  = 5
+	CostExplorerBurst              = 5
+	OrganizationsRequestsPerSecond = 4
+	OrganizationsBurst             = 8
+)
+
+// RateLimiter is a token bucket limiting the rate of requests. A nil RateLimiter does not limit.
+type RateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// now and sleep are replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewRateLimiter returns a token bucket refilling with rate tokens per second, holding up to burst tokens.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Wait blocks until a token is available and takes it.
+func (r *RateLimiter) Wait() {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	now := r.now()
+	r.tokens = math.Min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+	// take the token now, waiting for it to be refilled if the bucket is empty
+	r.tokens--
+	var wait time.Duration
+	if r.tokens < 0 {
+		wait = time.Duration(-r.tokens / r.rate * float64(time.Second))
+	}
+	r.mutex.Unlock()
+	r.sleep(wait)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestRateLimiter returns a rate limiter with a fake clock, sleeping advances the clock.
+// The returned functions advance the clock and return and reset the recorded waits.
+func newTestRateLimiter(rate float64, burst int) (*RateLimiter, func(time.Duration), func() []time.Duration) {
+	clock := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	waits := []time.Duration{}
+	limiter := NewRateLimiter(rate, burst)
+	limiter.last = clock
+	limiter.now = func() time.Time {
+		return clock
+	}
+	limiter.sleep = func(wait time.Duration) {
+		if wait > 0 {
+			waits = append(waits, wait)
+			clock = clock.Add(wait)
+		}
+	}
+	advance := func(duration time.Duration) {
+		clock = clock.Add(duration)
+	}
+	recorded := func() []time.Duration {
+		result := waits
+		waits = []time.Duration{}
+		return result
+	}
+	return limiter, advance, recorded
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	limiter, _, waits := newTestRateLimiter(2, 3)
+	for i := 0; i < 3; i++ {
+		limiter.Wait()
+	}
+	if recorded := waits(); len(recorded) != 0 {
+		t.Errorf("expected burst of 3 requests without waiting, got waits %v", recorded)
+	}
+	limiter.Wait()
+	limiter.Wait()
+	expected := []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}
+	if recorded := waits(); !reflect.DeepEqual(recorded, expected) {
+		t.Errorf("expected requests beyond the burst to wait for the refill interval %v, got %v", expected, recorded)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	limiter, advance, waits := newTestRateLimiter(4, 2)
+	limiter.Wait()
+	limiter.Wait()
+	advance(250 * time.Millisecond)
+	limiter.Wait()
+	if recorded := waits(); len(recorded) != 0 {
+		t.Errorf("expected one token to be refilled after 250ms, got waits %v", recorded)
+	}
+	// a long idle period refills the bucket up to the burst only
+	advance(time.Minute)
+	for i := 0; i < 3; i++ {
+		limiter.Wait()
+	}
+	expected := []time.Duration{250 * time.Millisecond}
+	if recorded := waits(); !reflect.DeepEqual(recorded, expected) {
+		t.Errorf("expected bucket to be capped at the burst, got waits %v", recorded)
+	}
+}
+
+func TestRateLimiterNil(t *testing.T) {
+	var limiter *RateLimiter
+	limiter.Wait()
+}
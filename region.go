@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// NoRegionValue is the region of costs not attributed to a region.
+const NoRegionValue = "NoRegion"
+
+// RegionRow describes the cost of an account in a region in a period, split by service if
+// pulled with services.
+type RegionRow struct {
+	Period     string
+	Region     string
+	Service    string
+	Cost       float64
+	Conversion Conversion
+}
+
+// PullRegions retrieves the cost of an account grouped by region, optionally split by
+// service, one row per period, region and service, sorted by period, region and service.
+// Amounts are converted into the reporting currency.
+func (a *AWSPuller) PullRegions(accountID string, dateRange DateRange, costType string, withServices bool) ([]RegionRow, error) {
+	if IsUsageMetric(costType) {
+		return nil, fmt.Errorf("usage metric %s can not be grouped by region", costType)
+	}
+	keys := []string{"REGION"}
+	if withServices {
+		keys = append(keys, "SERVICE")
+	}
+	results, err := a.PullGrouped(accountID, dateRange, costType, dimensionGroups(keys...), nil)
+	if err != nil {
+		return nil, err
+	}
+	rows := []RegionRow{}
+	for _, result := range results {
+		for _, group := range result.Groups {
+			if len(group.Keys) != len(keys) {
+				log.Printf("[pullawsregions] error: account %s region group does not have exactly %d keys", accountID, len(keys))
+				return nil, fmt.Errorf("[pullawsregions] error: account %s region group does not have exactly %d keys", accountID, len(keys))
+			}
+			conversion, err := a.groupConversion(result.Period, group)
+			if err != nil {
+				log.Printf("[pullawsregions] error converting account %s region cost: %v", accountID, err)
+				return nil, err
+			}
+			row := RegionRow{
+				Period:     result.Period.String(),
+				Region:     group.Keys[0],
+				Cost:       group.Amount * conversion.Rate,
+				Conversion: conversion,
+			}
+			if row.Region == "" {
+				row.Region = NoRegionValue
+			}
+			if withServices {
+				row.Service = group.Keys[1]
+			}
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Period != rows[j].Period {
+			return rows[i].Period < rows[j].Period
+		}
+		if rows[i].Region != rows[j].Region {
+			return rows[i].Region < rows[j].Region
+		}
+		return rows[i].Service < rows[j].Service
+	})
+	return rows, nil
+}
+
+// resultRegions returns the sorted regions of the region rows of the accounts.
+func resultRegions(rows []ReportRow, results *AccountResults) []string {
+	found := make(map[string]bool)
+	for _, row := range rows {
+		regions, _ := results.Get(row.AccountID).([]RegionRow)
+		for _, region := range regions {
+			found[region.Region] = true
+		}
+	}
+	sorted := []string{}
+	for region := range found {
+		sorted = append(sorted, region)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// regionLine holds the cost per region of one line of the region matrix.
+type regionLine struct {
+	service string
+	costs   map[string]float64
+}
+
+// regionLines sums up region rows of a period into lines, one per service if pulled with
+// services.
+func regionLines(lines []*regionLine, period string, regions []RegionRow) []*regionLine {
+	for _, region := range regions {
+		if region.Period != period {
+			continue
+		}
+		var line *regionLine
+		for _, existing := range lines {
+			if existing.service == region.Service {
+				line = existing
+				break
+			}
+		}
+		if line == nil {
+			line = &regionLine{service: region.Service, costs: make(map[string]float64)}
+			lines = append(lines, line)
+		}
+		line.costs[region.Region] += region.Cost
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].service < lines[j].service
+	})
+	return lines
+}
+
+// writeRegionCSV writes a region matrix per category and month: a line with the sum of the
+// category (account id ALL), followed by the lines of the accounts, with the cost per region
+// and the total. If pulled with services, there is a line per service. Failed accounts are
+// written at the end of their category.
+func writeRegionCSV(outfile *os.File, withServices bool, rows []ReportRow, results *AccountResults) error {
+	writer := csv.NewWriter(outfile)
+	defer writer.Flush()
+	regions := resultRegions(rows, results)
+	header := []string{"group", "date", "accountId"}
+	if withServices {
+		header = append(header, "service")
+	}
+	header = append(append(header, regions...), "total")
+	err := writer.Write(header)
+	if err != nil {
+		log.Printf("[writeregioncsv] error writing csv header to file: %v ", err)
+		return err
+	}
+	record := func(group string, date string, accountID string, line *regionLine) []string {
+		values := []string{group, date, accountID}
+		if withServices {
+			values = append(values, line.service)
+		}
+		var total float64 = 0
+		for _, region := range regions {
+			values = append(values, fmt.Sprintf("%f", line.costs[region]))
+			total += line.costs[region]
+		}
+		return append(values, fmt.Sprintf("%f", total))
+	}
+	for _, groupRows := range categoryRows(rows) {
+		group := groupRows[0].Group
+		lines := categoryMatrixLines(groupRows, len(header), func(date string, dateRows []ReportRow) [][]string {
+			category := []*regionLine{}
+			accounts := [][]string{}
+			for _, row := range dateRows {
+				accountRegions, _ := results.Get(row.AccountID).([]RegionRow)
+				for _, line := range regionLines(nil, date, accountRegions) {
+					accounts = append(accounts, record(group, date, row.AccountID, line))
+				}
+				category = regionLines(category, date, accountRegions)
+			}
+			lines := [][]string{}
+			for _, line := range category {
+				lines = append(lines, record(group, date, "ALL", line))
+			}
+			return append(lines, accounts...)
+		})
+		err := writer.WriteAll(lines)
+		if err != nil {
+			log.Printf("[writeregioncsv] error writing csv data to file: %v ", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPullRegions(t *testing.T) {
+	puller, _, _ := newFixturePuller(t, "costexplorer_regions.json")
+	dateRange := mustParseDateRange(t, "2026-01", "")
+	regions, err := puller.PullRegions("111111111111", dateRange, "UnblendedCost", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	usd := Conversion{Currency: "USD", OriginalCurrency: "USD", Rate: 1}
+	expected := []RegionRow{
+		{Period: "2026-01", Region: "NoRegion", Cost: 3, Conversion: usd},
+		{Period: "2026-01", Region: "eu-central-1", Cost: 100, Conversion: usd},
+		{Period: "2026-01", Region: "us-east-1", Cost: 20, Conversion: usd},
+	}
+	if !reflect.DeepEqual(regions, expected) {
+		t.Errorf("unexpected region costs: %+v", regions)
+	}
+	services, err := puller.PullRegions("111111111111", dateRange, "UnblendedCost", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	results := NewAccountResults()
+	results.Add("111111111111", services)
+	results.Add("222222222222", services[:1])
+	rows := []ReportRow{
+		*NewReportRow("a", "2026-01", "111111111111"),
+		*NewReportRow("a", "2026-01", "222222222222"),
+	}
+	records := readCSVOutput(t, func(outfile *os.File) error {
+		return writeRegionCSV(outfile, true, rows, results)
+	})
+	compute := "Amazon Elastic Compute Cloud - Compute"
+	storage := "Amazon Simple Storage Service"
+	expectedRecords := [][]string{
+		{"group", "date", "accountId", "service", "eu-central-1", "us-east-1", "total"},
+		{"a", "2026-01", "ALL", compute, "180.000000", "20.000000", "200.000000"},
+		{"a", "2026-01", "ALL", storage, "10.000000", "0.000000", "10.000000"},
+		{"a", "2026-01", "111111111111", compute, "90.000000", "20.000000", "110.000000"},
+		{"a", "2026-01", "111111111111", storage, "10.000000", "0.000000", "10.000000"},
+		{"a", "2026-01", "222222222222", compute, "90.000000", "0.000000", "90.000000"},
+	}
+	if !reflect.DeepEqual(records, expectedRecords) {
+		t.Errorf("unexpected region csv: %v", records)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// PendingValue marks row values that are not available from the pulled data.
+const PendingValue = "PENDING"
+
+// FailedValue marks the cost values of rows for accounts that failed to pull.
+const FailedValue = "FAILED"
+
+// ReportRow describes one normalized row of the output, shared by all pullers.
+type ReportRow struct {
+	Group        string
+	Date         string
+	ClusterID    string
+	AccountID    string
+	PO           string
+	ClusterType  string
+	UsageType    string
+	Product      string
+	Infra        string
+	NumberUsers  string
+	DataTransfer float64
+	Machines     float64
+	Storage      float64
+	KeyMgmnt     float64
+	Registrar    float64
+	DNS          float64
+	Other        float64
+	Tax          float64
+	Refund       float64
+	Credit       float64
+	// Conversion describes the conversion of the cost columns into the reporting currency
+	Conversion Conversion
+	// Failed is set to the failed stage if pulling the data failed
+	Failed string
+	// FromHistory is set if the row was read from the history database instead of pulled
+	FromHistory bool `json:"-"`
+	// Services is the service breakdown the row was normalized from
+	Services map[string]float64 `json:"-"`
+}
+
+var reportHeader = []string{
+	"group",
+	"date",
+	"clusterId",
+	"accountId",
+	"PO",
+	"clusterType",
+	"usageType",
+	"product",
+	"infra",
+	"numberUsers",
+	ColumnDataTransfer,
+	ColumnMachines,
+	ColumnStorage,
+	ColumnKeyMgmnt,
+	ColumnRegistrar,
+	ColumnDNS,
+	ColumnOther,
+	ColumnTax,
+	ColumnRefund,
+	ColumnCredit,
+	ColumnGross,
+	ColumnNet,
+	"currency",
+	"originalCurrency",
+	"exchangeRate",
+	"originalNet",
+}
+
+// NewReportRow returns a row for the given account with all metadata set to pending.
+func NewReportRow(group string, date string, accountID string) *ReportRow {
+	return &ReportRow{
+		Group:       group,
+		Date:        date,
+		ClusterID:   PendingValue,
+		AccountID:   accountID,
+		PO:          PendingValue,
+		ClusterType: PendingValue,
+		UsageType:   PendingValue,
+		Product:     PendingValue,
+		// infra is always AWS
+		Infra:       "AWS",
+		NumberUsers: PendingValue,
+		Conversion:  Conversion{Currency: DefaultCurrency, OriginalCurrency: DefaultCurrency, Rate: 1},
+	}
+}
+
+// SetAccountMetadata sets the metadata columns from the account entry. Returns the names
+// of the attributes not set on the account, these are left pending.
+func (r *ReportRow) SetAccountMetadata(account AccountEntry) []string {
+	missing := []string{}
+	for _, attribute := range []struct {
+		name   string
+		value  string
+		column *string
+	}{
+		{"po", account.PO, &r.PO},
+		{"clusterid", account.ClusterID, &r.ClusterID},
+		{"clustertype", account.ClusterType, &r.ClusterType},
+		{"usagetype", account.UsageType, &r.UsageType},
+		{"product", account.Product, &r.Product},
+		{"numberusers", account.NumberUsers, &r.NumberUsers},
+	} {
+		if attribute.value == "" {
+			missing = append(missing, attribute.name)
+			continue
+		}
+		*attribute.column = attribute.value
+	}
+	return missing
+}
+
+// AddColumns adds the values of normalized columns to the row.
+func (r *ReportRow) AddColumns(columns map[string]float64) {
+	for column, value := range columns {
+		switch column {
+		case ColumnDataTransfer:
+			r.DataTransfer += value
+		case ColumnMachines:
+			r.Machines += value
+		case ColumnStorage:
+			r.Storage += value
+		case ColumnKeyMgmnt:
+			r.KeyMgmnt += value
+		case ColumnRegistrar:
+			r.Registrar += value
+		case ColumnDNS:
+			r.DNS += value
+		case ColumnTax:
+			r.Tax += value
+		case ColumnRefund:
+			r.Refund += value
+		case ColumnCredit:
+			r.Credit += value
+		default:
+			r.Other += value
+		}
+	}
+}
+
+// AddRecordTypes adds the values of the separated record types to the credit, refund and
+// tax columns.
+func (r *ReportRow) AddRecordTypes(recordTypes map[string]float64) {
+	r.Credit += recordTypes[RecordTypeCredit]
+	r.Refund += recordTypes[RecordTypeRefund]
+	r.Tax += recordTypes[RecordTypeTax]
+}
+
+// Record returns the row as csv record in the order of the header. Failed rows have all
+// cost columns set to FAILED.
+func (r *ReportRow) Record() []string {
+	if r.Failed != "" {
+		return failedLine(len(reportHeader),
+			r.Group,
+			r.Date,
+			r.ClusterID,
+			r.AccountID,
+			r.PO,
+			r.ClusterType,
+			r.UsageType,
+			r.Product,
+			r.Infra,
+			r.NumberUsers,
+		)
+	}
+	return []string{
+		r.Group,
+		r.Date,
+		r.ClusterID,
+		r.AccountID,
+		r.PO,
+		r.ClusterType,
+		r.UsageType,
+		r.Product,
+		r.Infra,
+		r.NumberUsers,
+		fmt.Sprintf("%f", r.DataTransfer),
+		fmt.Sprintf("%f", r.Machines),
+		fmt.Sprintf("%f", r.Storage),
+		fmt.Sprintf("%f", r.KeyMgmnt),
+		fmt.Sprintf("%f", r.Registrar),
+		fmt.Sprintf("%f", r.DNS),
+		fmt.Sprintf("%f", r.Other),
+		fmt.Sprintf("%f", r.Tax),
+		fmt.Sprintf("%f", r.Refund),
+		fmt.Sprintf("%f", r.Credit),
+		fmt.Sprintf("%f", r.Gross()),
+		fmt.Sprintf("%f", r.Total()),
+		r.Conversion.Currency,
+		r.Conversion.OriginalCurrency,
+		fmt.Sprintf("%f", r.Conversion.Rate),
+		fmt.Sprintf("%f", r.Conversion.Original(r.Total())),
+	}
+}
+
+// Gross returns the sum of the consumed cost columns, without tax, refunds and credits.
+func (r *ReportRow) Gross() float64 {
+	return r.DataTransfer + r.Machines + r.Storage + r.KeyMgmnt + r.Registrar + r.DNS + r.Other
+}
+
+// Total returns the net sum of all cost columns.
+func (r *ReportRow) Total() float64 {
+	return r.Gross() + r.Tax + r.Refund + r.Credit
+}
+
+// writeCSV writes the rows with a leading header row.
+func writeCSV(outfile *os.File, rows []ReportRow) error {
+	writer := csv.NewWriter(outfile)
+	defer writer.Flush()
+	err := writer.Write(reportHeader)
+	if err != nil {
+		log.Printf("[writecsv] error writing csv header to file: %v ", err)
+		return err
+	}
+	for _, row := range rows {
+		err := writer.Write(row.Record())
+		if err != nil {
+			log.Printf("[writecsv] error writing csv data to file: %v ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// failedLine returns the values of a failed row, followed by FailedValue up to the given number
+// of columns.
+func failedLine(columns int, values ...string) []string {
+	line := append([]string{}, values...)
+	for len(line) < columns {
+		line = append(line, FailedValue)
+	}
+	return line
+}
+
+// categoryRows splits rows sorted by category into the rows of each category.
+func categoryRows(rows []ReportRow) [][]ReportRow {
+	categories := [][]ReportRow{}
+	for start := 0; start < len(rows); {
+		end := start
+		for end < len(rows) && rows[end].Group == rows[start].Group {
+			end++
+		}
+		categories = append(categories, rows[start:end])
+		start = end
+	}
+	return categories
+}
+
+// categoryMatrixLines returns the lines of a category in the matrix outputs: the lines returned
+// by dateLines for the rows of every date in order of the dates, followed by a failed line
+// with group, date and account id for every failed row.
+func categoryMatrixLines(rows []ReportRow, columns int, dateLines func(date string, rows []ReportRow) [][]string) [][]string {
+	dates := []string{}
+	dateRows := make(map[string][]ReportRow)
+	failed := [][]string{}
+	for _, row := range rows {
+		if row.Failed != "" {
+			failed = append(failed, failedLine(columns, row.Group, row.Date, row.AccountID))
+			continue
+		}
+		if _, ok := dateRows[row.Date]; !ok {
+			dates = append(dates, row.Date)
+		}
+		dateRows[row.Date] = append(dateRows[row.Date], row)
+	}
+	sort.Strings(dates)
+	lines := [][]string{}
+	for _, date := range dates {
+		lines = append(lines, dateLines(date, dateRows[date])...)
+	}
+	return append(lines, failed...)
+}
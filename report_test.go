@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFailedLine(t *testing.T) {
+	line := failedLine(5, "a", "2026-01", "111111111111")
+	expected := []string{"a", "2026-01", "111111111111", FailedValue, FailedValue}
+	if !reflect.DeepEqual(line, expected) {
+		t.Errorf("unexpected failed line: %v", line)
+	}
+	failed := NewReportRow("a", "2026-01", "111111111111")
+	failed.Failed = StageAWSPull
+	record := failed.Record()
+	if len(record) != len(reportHeader) || record[3] != "111111111111" || record[len(record)-1] != FailedValue {
+		t.Errorf("unexpected failed record: %v", record)
+	}
+}
+
+func TestCategoryMatrixLines(t *testing.T) {
+	failed := NewReportRow("a", "2026-01", "333333333333")
+	failed.Failed = StageAWSPull
+	rows := []ReportRow{
+		*NewReportRow("a", "2026-02", "111111111111"),
+		*NewReportRow("a", "2026-01", "111111111111"),
+		*failed,
+		*NewReportRow("a", "2026-01", "222222222222"),
+		*NewReportRow("b", "2026-01", "444444444444"),
+	}
+	categories := categoryRows(rows)
+	if len(categories) != 2 || len(categories[0]) != 4 || categories[1][0].Group != "b" {
+		t.Fatalf("unexpected categories: %v", categories)
+	}
+	lines := categoryMatrixLines(categories[0], 4, func(date string, dateRows []ReportRow) [][]string {
+		lines := [][]string{{"a", date, "ALL", ""}}
+		for _, row := range dateRows {
+			lines = append(lines, []string{row.Group, date, row.AccountID, ""})
+		}
+		return lines
+	})
+	// dates are in order, failed accounts follow at the end of the category
+	expected := [][]string{
+		{"a", "2026-01", "ALL", ""},
+		{"a", "2026-01", "111111111111", ""},
+		{"a", "2026-01", "222222222222", ""},
+		{"a", "2026-02", "ALL", ""},
+		{"a", "2026-02", "111111111111", ""},
+		{"a", "2026-01", "333333333333", FailedValue},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected matrix lines: %v", lines)
+	}
+}
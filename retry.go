@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// DefaultMaxAttempts is the default number of attempts for retryable requests.
+const DefaultMaxAttempts = 5
+
+// DefaultRetryDelay is the default delay before the first retry, doubled on every retry.
+const DefaultRetryDelay = time.Second
+
+// maxRetryDelay caps the delay between retries.
+const maxRetryDelay = time.Minute
+
+// retryableAWSCodes are retryable AWS error codes of Cost Explorer and Organizations not
+// covered by the SDK classification.
+var retryableAWSCodes = map[string]bool{
+	"LimitExceededException":          true,
+	"TooManyRequestsException":        true,
+	"ConcurrentModificationException": true,
+	"ServiceException":                true,
+}
+
+// HTTPStatusError describes a request that failed with a non-successful status code.
+type HTTPStatusError struct {
+	StatusCode int
+	URL        string
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("error fetching data from service, returned status %d, url was %s\nBody: %s", e.StatusCode, e.URL, e.Body)
+}
+
+// Retrier retries operations failing with retryable errors, using exponential backoff with
+// jitter. A nil Retrier runs operations exactly once.
+type Retrier struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	// OnRetry is called before waiting for a retry, if set.
+	OnRetry func(operation string, attempt int, delay time.Duration, err error)
+}
+
+// NewRetrier returns a new Retrier.
+func NewRetrier(maxAttempts int, baseDelay time.Duration) *Retrier {
+	r := new(Retrier)
+	r.MaxAttempts = maxAttempts
+	r.BaseDelay = baseDelay
+	return r
+}
+
+// Do runs the operation until it succeeds, fails with an error that is not retryable or the
+// maximum number of attempts is reached.
+func (r *Retrier) Do(operation string, fn func() error) error {
+	if r == nil {
+		return fn()
+	}
+	attempt := 1
+	for {
+		err := fn()
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+		if attempt >= r.MaxAttempts {
+			log.Printf("[retry] giving up on %s after %d attempts: %v", operation, attempt, err)
+			return err
+		}
+		delay := r.delay(attempt)
+		log.Printf("[retry] attempt %d of %d for %s failed, retrying in %s: %v", attempt, r.MaxAttempts, operation, delay, err)
+		if r.OnRetry != nil {
+			r.OnRetry(operation, attempt, delay, err)
+		}
+		time.Sleep(delay)
+		attempt++
+	}
+}
+
+// delay returns the jittered delay before the retry following the given attempt.
+func (r *Retrier) delay(attempt int) time.Duration {
+	backoff := r.BaseDelay << uint(attempt-1)
+	if backoff > maxRetryDelay || backoff <= 0 {
+		backoff = maxRetryDelay
+	}
+	// full jitter between half and the complete backoff
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// IsRetryable classifies errors as retryable (throttling, server errors and timeouts) or fatal.
+func IsRetryable(err error) bool {
+	switch e := err.(type) {
+	case *HTTPStatusError:
+		return e.StatusCode == 429 || e.StatusCode >= 500
+	case awserr.RequestFailure:
+		if e.StatusCode() == 429 || e.StatusCode() >= 500 {
+			return true
+		}
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		if retryableAWSCodes[awsErr.Code()] || request.IsErrorThrottle(err) || request.IsErrorRetryable(err) {
+			return true
+		}
+		if awsErr.OrigErr() != nil {
+			return IsRetryable(awsErr.OrigErr())
+		}
+		return false
+	}
+	if netErr, ok := err.(net.Error); ok {
+		return netErr.Timeout()
+	}
+	return false
+}
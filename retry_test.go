@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err       error
+		retryable bool
+	}{
+		{awserr.New("LimitExceededException", "rate exceeded", nil), true},
+		{awserr.New("ThrottlingException", "rate exceeded", nil), true},
+		{awserr.NewRequestFailure(awserr.New("InternalError", "internal", nil), 503, "id"), true},
+		{awserr.New("AccessDeniedException", "denied", nil), false},
+		{&HTTPStatusError{StatusCode: 429}, true},
+		{&HTTPStatusError{StatusCode: 502}, true},
+		{&HTTPStatusError{StatusCode: 401}, false},
+		{errors.New("parse error"), false},
+	}
+	for _, c := range cases {
+		if IsRetryable(c.err) != c.retryable {
+			t.Errorf("expected retryable to be %t for %v", c.retryable, c.err)
+		}
+	}
+}
+
+func TestRetrierDo(t *testing.T) {
+	retrier := NewRetrier(3, time.Millisecond)
+	retries := 0
+	retrier.OnRetry = func(operation string, attempt int, delay time.Duration, err error) {
+		retries++
+	}
+	calls := 0
+	err := retrier.Do("test", func() error {
+		calls++
+		if calls < 3 {
+			return &HTTPStatusError{StatusCode: 503}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 || retries != 2 {
+		t.Errorf("expected success on third attempt, got %v after %d calls and %d retries", err, calls, retries)
+	}
+	calls = 0
+	err = retrier.Do("test", func() error {
+		calls++
+		return &HTTPStatusError{StatusCode: 401}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("expected fatal error without retry, got %v after %d calls", err, calls)
+	}
+	calls = 0
+	err = retrier.Do("test", func() error {
+		calls++
+		return &HTTPStatusError{StatusCode: 429}
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("expected error after max attempts, got %v after %d calls", err, calls)
+	}
+}
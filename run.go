@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Stages of an account pull, used for reporting failures.
+const (
+	StageAWSPull      = "aws pull"
+	StageAWSNormalize = "aws normalize"
+	StageAWSForecast  = "aws forecast"
+	StageCMPull       = "cm pull"
+	StageCMParse      = "cm parse"
+	StageCMPeriod     = "cm period check"
+	StageCMNormalize  = "cm normalize"
+)
+
+// StageError annotates an error with the pull stage and period it occurred in.
+type StageError struct {
+	Stage  string
+	Period string
+	Err    error
+}
+
+func (e *StageError) Error() string {
+	if e.Period != "" {
+		return fmt.Sprintf("%s failed for %s: %v", e.Stage, e.Period, e.Err)
+	}
+	return fmt.Sprintf("%s failed: %v", e.Stage, e.Err)
+}
+
+// PullFailure describes a failed pull for an account.
+type PullFailure struct {
+	AccountID string
+	Category  string
+	Stage     string
+	Err       error
+}
+
+// accountJob pulls the rows for one account of a category.
+type accountJob func(category string, account AccountEntry) ([]ReportRow, error)
+
+// runAccounts runs the job for all accounts using up to concurrency parallel workers. The
+// rows are returned sorted by category and account. If keepGoing is set, failed accounts are
+// recorded and get a failed row in the output, otherwise the run is aborted on the first failure.
+func runAccounts(accounts map[string][]AccountEntry, keepGoing bool, concurrency int, dateRange DateRange, job accountJob) ([]ReportRow, []PullFailure) {
+	type accountTask struct {
+		category string
+		account  AccountEntry
+		rows     []ReportRow
+		err      error
+	}
+	tasks := []*accountTask{}
+	for _, category := range sortedKeys(accounts) {
+		categoryAccounts := append([]AccountEntry{}, accounts[category]...)
+		sort.SliceStable(categoryAccounts, func(i, j int) bool {
+			return categoryAccounts[i].AccountID < categoryAccounts[j].AccountID
+		})
+		for _, account := range categoryAccounts {
+			tasks = append(tasks, &accountTask{category: category, account: account})
+		}
+	}
+	runParallel(len(tasks), concurrency, func(idx int) {
+		task := tasks[idx]
+		log.Printf("[runaccounts] pulling data for account %s (group %s)\n", task.account.AccountID, task.category)
+		task.rows, task.err = job(task.category, task.account)
+		if task.err != nil && !keepGoing {
+			log.Fatalf("[runaccounts] error pulling data: %v", task.err)
+		}
+	})
+	rows := []ReportRow{}
+	failures := []PullFailure{}
+	for _, task := range tasks {
+		rows = append(rows, task.rows...)
+		if task.err == nil {
+			continue
+		}
+		log.Printf("[runaccounts] error pulling data for account %s, continued: %v", task.account.AccountID, task.err)
+		failure := PullFailure{
+			AccountID: task.account.AccountID,
+			Category:  task.category,
+			Stage:     "unknown",
+			Err:       task.err,
+		}
+		date := dateRange.String()
+		if stageErr, ok := task.err.(*StageError); ok {
+			failure.Stage = stageErr.Stage
+			failure.Err = stageErr.Err
+			if stageErr.Period != "" {
+				date = stageErr.Period
+			}
+		}
+		failures = append(failures, failure)
+		failedRow := NewReportRow(task.category, date, task.account.AccountID)
+		failedRow.SetAccountMetadata(task.account)
+		failedRow.Failed = failure.Stage
+		rows = append(rows, *failedRow)
+	}
+	return rows, failures
+}
+
+// runParallel calls fn for the indexes 0 to n-1 using up to concurrency parallel workers and
+// waits for all calls to finish.
+func runParallel(n int, concurrency int, fn func(idx int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				fn(idx)
+			}
+		}()
+	}
+	for idx := 0; idx < n; idx++ {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// writeFailureSummary logs the failed accounts and writes them to the report file.
+func writeFailureSummary(reportfile *os.File, failures []PullFailure) {
+	if len(failures) == 0 {
+		return
+	}
+	log.Printf("[main] %d accounts failed:", len(failures))
+	writeReport(reportfile, fmt.Sprintf("%d accounts failed:", len(failures)))
+	for _, failure := range failures {
+		line := fmt.Sprintf("%s (group %s), stage %s: %v", failure.AccountID, failure.Category, failure.Stage, failure.Err)
+		log.Printf("[main]   %s", line)
+		writeReport(reportfile, "  "+line)
+	}
+}
+
+// AccountResults collects the mode specific results of parallel account pulls by account id,
+// eg. the usage rows of an account in usage mode.
+type AccountResults struct {
+	mutex   sync.Mutex
+	results map[string]interface{}
+}
+
+// NewAccountResults returns a new empty result set.
+func NewAccountResults() *AccountResults {
+	results := new(AccountResults)
+	results.results = make(map[string]interface{})
+	return results
+}
+
+// Add stores the result of an account.
+func (r *AccountResults) Add(accountID string, result interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.results[accountID] = result
+}
+
+// Get returns the result of an account, nil if none is stored.
+func (r *AccountResults) Get(accountID string) interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.results[accountID]
+}
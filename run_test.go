@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunAccountsKeepGoing(t *testing.T) {
+	accounts := map[string][]AccountEntry{
+		"b": []AccountEntry{AccountEntry{AccountID: "333333333333"}},
+		"a": []AccountEntry{AccountEntry{AccountID: "222222222222"}, AccountEntry{AccountID: "111111111111"}},
+	}
+	dateRange := mustParseDateRange(t, "2026-01", "")
+	results := NewAccountResults()
+	rows, failures := runAccounts(accounts, true, 2, dateRange, func(category string, account AccountEntry) ([]ReportRow, error) {
+		if account.AccountID == "222222222222" {
+			return nil, &StageError{Stage: StageAWSPull, Err: errors.New("throttled")}
+		}
+		results.Add(account.AccountID, category)
+		return []ReportRow{*NewReportRow(category, dateRange.String(), account.AccountID)}, nil
+	})
+	if len(rows) != 3 {
+		t.Fatalf("expected a row per account, got %d", len(rows))
+	}
+	if rows[1].AccountID != "222222222222" || rows[1].Failed != StageAWSPull || rows[1].Record()[len(reportHeader)-1] != FailedValue {
+		t.Errorf("expected failed row for account, got %v", rows[1])
+	}
+	if rows[2].Group != "b" {
+		t.Errorf("expected rows sorted by category, got %v", rows)
+	}
+	if rows[0].AccountID != "111111111111" {
+		t.Errorf("expected rows sorted by account, got %v", rows)
+	}
+	if len(failures) != 1 || failures[0].Category != "a" || failures[0].Stage != StageAWSPull || failures[0].Err.Error() != "throttled" {
+		t.Errorf("unexpected failures: %v", failures)
+	}
+	if results.Get("333333333333") != "b" || results.Get("222222222222") != nil {
+		t.Errorf("expected results of successful accounts only, got %v", results.results)
+	}
+}
+
+func TestPullAccountResults(t *testing.T) {
+	results := NewAccountResults()
+	account := AccountEntry{AccountID: "111111111111"}
+	rows, err := pullAccountResults(results, "a", account, mustParseDateRange(t, "2026-01", "2026-02"), "usage", func() (interface{}, error) {
+		return []UsageRow{{Period: "2026-01"}}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rows) != 2 || rows[0].Date != "2026-01" || rows[1].Date != "2026-02" || rows[1].Group != "a" {
+		t.Errorf("expected a row per month, got %v", rows)
+	}
+	if usage, ok := results.Get(account.AccountID).([]UsageRow); !ok || len(usage) != 1 {
+		t.Errorf("expected stored usage, got %v", results.Get(account.AccountID))
+	}
+	account.AccountID = "222222222222"
+	_, err = pullAccountResults(results, "a", account, mustParseDateRange(t, "2026-01", ""), "usage", func() (interface{}, error) {
+		return nil, errors.New("throttled")
+	})
+	if stageErr, ok := err.(*StageError); !ok || stageErr.Stage != StageAWSPull {
+		t.Errorf("expected aws pull stage error, got %v", err)
+	}
+	if results.Get(account.AccountID) != nil {
+		t.Error("expected no result for failed pull")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+)
+
+// UntaggedValue is the tag value of spend without the cost allocation tag.
+const UntaggedValue = "UNTAGGED"
+
+// TagRow describes the cost of an account for a cost allocation tag value in a period, split
+// by service if pulled with services.
+type TagRow struct {
+	Period     string
+	Tag        string
+	Service    string
+	Cost       float64
+	Conversion Conversion
+}
+
+// tagGroups returns the group definitions for a cost allocation tag key, optionally combined
+// with the service dimension.
+func tagGroups(tagKey string, withServices bool) []*costexplorer.GroupDefinition {
+	groupByTag := "TAG"
+	groups := []*costexplorer.GroupDefinition{{
+		Type: &groupByTag,
+		Key:  &tagKey,
+	}}
+	if withServices {
+		groups = append(groups, dimensionGroups("SERVICE")...)
+	}
+	return groups
+}
+
+// tagValue returns the value of a tag group key in the format key$value. Spend without the
+// tag has an empty value and is returned as UntaggedValue.
+func tagValue(tagKey string, groupKey string) string {
+	value := strings.TrimPrefix(groupKey, tagKey+"$")
+	if value == "" {
+		return UntaggedValue
+	}
+	return value
+}
+
+// PullTags retrieves the cost of an account grouped by the values of a cost allocation tag,
+// optionally split by service, one row per period, tag value and service, sorted by period,
+// tag value and service. Amounts are converted into the reporting currency.
+func (a *AWSPuller) PullTags(accountID string, dateRange DateRange, costType string, tagKey string, withServices bool) ([]TagRow, error) {
+	if IsUsageMetric(costType) {
+		return nil, fmt.Errorf("usage metric %s can not be grouped by tag", costType)
+	}
+	results, err := a.PullGrouped(accountID, dateRange, costType, tagGroups(tagKey, withServices), nil)
+	if err != nil {
+		return nil, err
+	}
+	keys := 1
+	if withServices {
+		keys = 2
+	}
+	rows := []TagRow{}
+	for _, result := range results {
+		for _, group := range result.Groups {
+			if len(group.Keys) != keys {
+				log.Printf("[pullawstags] error: account %s tag group does not have exactly %d keys", accountID, keys)
+				return nil, fmt.Errorf("[pullawstags] error: account %s tag group does not have exactly %d keys", accountID, keys)
+			}
+			conversion, err := a.groupConversion(result.Period, group)
+			if err != nil {
+				log.Printf("[pullawstags] error converting account %s tag cost: %v", accountID, err)
+				return nil, err
+			}
+			row := TagRow{
+				Period:     result.Period.String(),
+				Tag:        tagValue(tagKey, group.Keys[0]),
+				Cost:       group.Amount * conversion.Rate,
+				Conversion: conversion,
+			}
+			if withServices {
+				row.Service = group.Keys[1]
+			}
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Period != rows[j].Period {
+			return rows[i].Period < rows[j].Period
+		}
+		if rows[i].Tag != rows[j].Tag {
+			return rows[i].Tag < rows[j].Tag
+		}
+		return rows[i].Service < rows[j].Service
+	})
+	return rows, nil
+}
+
+// writeTagCSV writes the tag rows of the accounts in the order of the rows, one line per
+// period, tag value and, if pulled with services, service. Failed accounts get a single
+// failed line.
+func writeTagCSV(outfile *os.File, tagKey string, withServices bool, rows []ReportRow, results *AccountResults) error {
+	writer := csv.NewWriter(outfile)
+	defer writer.Flush()
+	header := []string{"group", "date", "accountId", tagKey}
+	if withServices {
+		header = append(header, "service")
+	}
+	header = append(header, "cost", "currency", "originalCurrency", "exchangeRate")
+	err := writer.Write(header)
+	if err != nil {
+		log.Printf("[writetagcsv] error writing csv header to file: %v ", err)
+		return err
+	}
+	for _, row := range rows {
+		if row.Failed != "" {
+			err := writer.Write(failedLine(len(header), row.Group, row.Date, row.AccountID))
+			if err != nil {
+				log.Printf("[writetagcsv] error writing csv data to file: %v ", err)
+				return err
+			}
+			continue
+		}
+		tagRows, _ := results.Get(row.AccountID).([]TagRow)
+		for _, tag := range tagRows {
+			if tag.Period != row.Date {
+				continue
+			}
+			line := []string{row.Group, tag.Period, row.AccountID, tag.Tag}
+			if withServices {
+				line = append(line, tag.Service)
+			}
+			line = append(line,
+				fmt.Sprintf("%f", tag.Cost),
+				tag.Conversion.Currency,
+				tag.Conversion.OriginalCurrency,
+				fmt.Sprintf("%f", tag.Conversion.Rate),
+			)
+			err := writer.Write(line)
+			if err != nil {
+				log.Printf("[writetagcsv] error writing csv data to file: %v ", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPullTags(t *testing.T) {
+	puller, _, _ := newFixturePuller(t, "costexplorer_tags.json")
+	dateRange := mustParseDateRange(t, "2026-01", "")
+	usd := Conversion{Currency: "USD", OriginalCurrency: "USD", Rate: 1}
+	tags, err := puller.PullTags("111111111111", dateRange, "UnblendedCost", "cost-center", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []TagRow{
+		{Period: "2026-01", Tag: UntaggedValue, Cost: 15.5, Conversion: usd},
+		{Period: "2026-01", Tag: "team-a", Cost: 100, Conversion: usd},
+		{Period: "2026-01", Tag: "team-b", Cost: 40, Conversion: usd},
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("unexpected tag costs: %+v", tags)
+	}
+	services, err := puller.PullTags("111111111111", dateRange, "UnblendedCost", "cost-center", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = []TagRow{
+		{Period: "2026-01", Tag: UntaggedValue, Service: "Tax", Cost: 15.5, Conversion: usd},
+		{Period: "2026-01", Tag: "team-a", Service: "Amazon Elastic Compute Cloud - Compute", Cost: 90, Conversion: usd},
+		{Period: "2026-01", Tag: "team-a", Service: "Amazon Simple Storage Service", Cost: 10, Conversion: usd},
+	}
+	if !reflect.DeepEqual(services, expected) {
+		t.Errorf("unexpected tag costs by service: %+v", services)
+	}
+	if _, err := puller.PullTags("111111111111", dateRange, "UsageQuantity", "cost-center", false); err == nil {
+		t.Error("expected error for usage metric")
+	}
+	results := NewAccountResults()
+	results.Add("111111111111", services[:2])
+	records := readCSVOutput(t, func(outfile *os.File) error {
+		return writeTagCSV(outfile, "cost-center", true, []ReportRow{*NewReportRow("a", "2026-01", "111111111111")}, results)
+	})
+	expectedRecords := [][]string{
+		{"group", "date", "accountId", "cost-center", "service", "cost", "currency", "originalCurrency", "exchangeRate"},
+		{"a", "2026-01", "111111111111", UntaggedValue, "Tax", "15.500000", "USD", "USD", "1.000000"},
+		{"a", "2026-01", "111111111111", "team-a", "Amazon Elastic Compute Cloud - Compute", "90.000000", "USD", "USD", "1.000000"},
+	}
+	if !reflect.DeepEqual(records, expectedRecords) {
+		t.Errorf("unexpected tag csv: %v", records)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// usageMetrics are the cost types measuring usage in their own units instead of USD.
+var usageMetrics = map[string]bool{
+	"UsageQuantity":         true,
+	"NormalizedUsageAmount": true,
+}
+
+// IsUsageMetric returns true if the cost type is a usage metric.
+func IsUsageMetric(costType string) bool {
+	return usageMetrics[costType]
+}
+
+// UsageRow describes the usage of an account for a service and usage type in a period.
+type UsageRow struct {
+	Period    string
+	Service   string
+	UsageType string
+	Unit      string
+	Quantity  float64
+}
+
+// PullUsage retrieves the usage of an account grouped by service and usage type, one row per
+// period, service and usage type, sorted by period, service and usage type.
+func (a *AWSPuller) PullUsage(accountID string, dateRange DateRange, metric string) ([]UsageRow, error) {
+	if !IsUsageMetric(metric) {
+		return nil, fmt.Errorf("%s is not a usage metric", metric)
+	}
+	results, err := a.PullGrouped(accountID, dateRange, metric, dimensionGroups("SERVICE", "USAGE_TYPE"), nil)
+	if err != nil {
+		return nil, err
+	}
+	rows := []UsageRow{}
+	for _, result := range results {
+		for _, group := range result.Groups {
+			if len(group.Keys) != 2 {
+				log.Printf("[pullawsusage] error: account %s usage group does not have exactly two keys", accountID)
+				return nil, fmt.Errorf("[pullawsusage] error: account %s usage group does not have exactly two keys", accountID)
+			}
+			rows = append(rows, UsageRow{
+				Period:    result.Period.String(),
+				Service:   group.Keys[0],
+				UsageType: group.Keys[1],
+				Unit:      group.Unit,
+				Quantity:  group.Amount,
+			})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Period != rows[j].Period {
+			return rows[i].Period < rows[j].Period
+		}
+		if rows[i].Service != rows[j].Service {
+			return rows[i].Service < rows[j].Service
+		}
+		return rows[i].UsageType < rows[j].UsageType
+	})
+	return rows, nil
+}
+
+// writeUsageCSV writes the usage rows of the accounts in the order of the rows, one line per
+// period, service and usage type with its unit. Failed accounts get a single failed line.
+func writeUsageCSV(outfile *os.File, metric string, rows []ReportRow, results *AccountResults) error {
+	writer := csv.NewWriter(outfile)
+	defer writer.Flush()
+	header := []string{"group", "date", "accountId", "service", "usageType", "unit", metric}
+	err := writer.Write(header)
+	if err != nil {
+		log.Printf("[writeusagecsv] error writing csv header to file: %v ", err)
+		return err
+	}
+	for _, row := range rows {
+		if row.Failed != "" {
+			err := writer.Write(failedLine(len(header), row.Group, row.Date, row.AccountID))
+			if err != nil {
+				log.Printf("[writeusagecsv] error writing csv data to file: %v ", err)
+				return err
+			}
+			continue
+		}
+		usageRows, _ := results.Get(row.AccountID).([]UsageRow)
+		for _, usage := range usageRows {
+			if usage.Period != row.Date {
+				continue
+			}
+			err := writer.Write([]string{row.Group, usage.Period, row.AccountID, usage.Service, usage.UsageType, usage.Unit, fmt.Sprintf("%f", usage.Quantity)})
+			if err != nil {
+				log.Printf("[writeusagecsv] error writing csv data to file: %v ", err)
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPullUsage(t *testing.T) {
+	puller, _, _ := newFixturePuller(t, "costexplorer_usage.json")
+	dateRange := mustParseDateRange(t, "2026-01", "")
+	usage, err := puller.PullUsage("111111111111", dateRange, "UsageQuantity")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []UsageRow{
+		{Period: "2026-01", Service: "Amazon Elastic Compute Cloud - Compute", UsageType: "BoxUsage:m5.xlarge", Unit: "Hrs", Quantity: 744},
+		{Period: "2026-01", Service: "Amazon Simple Storage Service", UsageType: "Requests-Tier1", Unit: "Requests", Quantity: 10000},
+		{Period: "2026-01", Service: "Amazon Simple Storage Service", UsageType: "TimedStorage-ByteHrs", Unit: "GB-Mo", Quantity: 512.5},
+	}
+	if !reflect.DeepEqual(usage, expected) {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+	if _, err := puller.PullUsage("111111111111", dateRange, "UnblendedCost"); err == nil {
+		t.Error("expected error for cost metric")
+	}
+	if _, err := puller.PullData("111111111111", dateRange, "UsageQuantity"); err == nil {
+		t.Error("expected error for pulling usage metric as cost")
+	}
+	results := NewAccountResults()
+	results.Add("111111111111", usage[:1])
+	records := readCSVOutput(t, func(outfile *os.File) error {
+		return writeUsageCSV(outfile, "UsageQuantity", []ReportRow{*NewReportRow("a", "2026-01", "111111111111")}, results)
+	})
+	expectedRecords := [][]string{
+		{"group", "date", "accountId", "service", "usageType", "unit", "UsageQuantity"},
+		{"a", "2026-01", "111111111111", "Amazon Elastic Compute Cloud - Compute", "BoxUsage:m5.xlarge", "Hrs", "744.000000"},
+	}
+	if !reflect.DeepEqual(records, expectedRecords) {
+		t.Errorf("unexpected usage csv: %v", records)
+	}
+}